@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/operator"
+)
+
+// status prints a table with the phase, versions and update_engine status of managed nodes.
+func (c *cli) status(args []string) error {
+	var selector string
+
+	fs := newFlagSet("status", &selector)
+	_ = fs.Parse(args) // Exits on error.
+
+	nodes, err := c.selectNodes(fs.Args(), selector)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 3, ' ', 0) //nolint:mnd // Padding between columns.
+
+	fmt.Fprintln(w, "NODE\tPHASE\tVERSION\tNEW VERSION\tSTATUS")
+
+	for _, node := range nodes {
+		if !managed(node) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node.Name, operator.NodePhase(node),
+			valueOrNone(node.Labels[constants.LabelVersion]),
+			valueOrNone(node.Annotations[constants.AnnotationNewVersion]),
+			valueOrNone(node.Annotations[constants.AnnotationStatus]))
+	}
+
+	return w.Flush() //nolint:wrapcheck // No context to add.
+}
+
+// valueOrNone returns the given value or a placeholder, if it is empty.
+func valueOrNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// pause sets the reboot-paused annotation on nodes, so they are not chosen for reboot.
+func (c *cli) pause(args []string) error {
+	return c.updateNodes("pause", args, func(node corev1.Node) error {
+		return k8sutil.SetNodeAnnotations(c.kc.CoreV1().Nodes(), node.Name, map[string]string{
+			constants.AnnotationRebootPaused: constants.True,
+		})
+	})
+}
+
+// resume removes the reboot-paused annotation from nodes, so they may be chosen for reboot again.
+func (c *cli) resume(args []string) error {
+	return c.updateNodes("resume", args, func(node corev1.Node) error {
+		return k8sutil.DeleteNodeAnnotations(c.kc.CoreV1().Nodes(), node.Name,
+			[]string{constants.AnnotationRebootPaused})
+	})
+}
+
+// approve sets annotations of the current before-reboot or after-reboot phase of nodes to "true".
+func (c *cli) approve(args []string) error {
+	return c.setPhaseAnnotations("approve", args, constants.True)
+}
+
+// reject sets annotations of the current before-reboot or after-reboot phase of nodes to "false",
+// which makes the update-operator abort the reboot.
+func (c *cli) reject(args []string) error {
+	return c.setPhaseAnnotations("reject", args, constants.False)
+}
+
+// setPhaseAnnotations sets annotations of the current before-reboot or after-reboot phase of
+// selected nodes to the given value. Annotations are taken from the -annotations flag or from the
+// update-operator configuration.
+func (c *cli) setPhaseAnnotations(name string, args []string, value string) error {
+	var (
+		selector    string
+		annotations string
+	)
+
+	fs := newFlagSet(name, &selector)
+	fs.StringVar(&annotations, "annotations", "",
+		"Comma-separated list of annotations to set. Defaults to all annotations of the current phase "+
+			"of the node configured in the update-operator")
+	_ = fs.Parse(args) // Exits on error.
+
+	var config *updatev1alpha1.UpdatePolicySpec
+
+	if annotations == "" {
+		var err error
+
+		if config, err = c.operatorConfig(); err != nil {
+			return fmt.Errorf("getting update-operator configuration: %w", err)
+		}
+
+		if config == nil {
+			return fmt.Errorf("update-operator configuration is not reported in any UpdatePolicy, " +
+				"select annotations using -annotations or run update-operator with --update-policy")
+		}
+	}
+
+	return c.updateSelectedNodes(name, fs, selector, func(node corev1.Node) error {
+		phaseAnnotations, err := phaseAnnotations(node, config, annotations)
+		if err != nil {
+			return err
+		}
+
+		values := map[string]string{}
+
+		for _, annotation := range phaseAnnotations {
+			values[annotation] = value
+		}
+
+		return k8sutil.SetNodeAnnotations(c.kc.CoreV1().Nodes(), node.Name, values)
+	})
+}
+
+// phaseAnnotations returns annotations to set on the given node. If annotations are not given
+// explicitly, configured annotations of the current phase of the node are returned.
+func phaseAnnotations(node corev1.Node, config *updatev1alpha1.UpdatePolicySpec,
+	annotations string) ([]string, error) {
+	phase := operator.NodePhase(node)
+
+	if phase != updatev1alpha1.NodeUpdatePhaseBeforeReboot && phase != updatev1alpha1.NodeUpdatePhaseAfterReboot {
+		return nil, fmt.Errorf("node is in phase %q, not running before-reboot or after-reboot checks", phase)
+	}
+
+	if annotations != "" {
+		return strings.Split(annotations, ","), nil
+	}
+
+	configured := config.BeforeRebootAnnotations
+	if phase == updatev1alpha1.NodeUpdatePhaseAfterReboot {
+		configured = config.AfterRebootAnnotations
+	}
+
+	if len(configured) == 0 {
+		return nil, fmt.Errorf("no annotations are configured for phase %q", phase)
+	}
+
+	return configured, nil
+}
+
+// updateNodes parses flags of the given subcommand and updates selected nodes using f.
+func (c *cli) updateNodes(name string, args []string, f func(corev1.Node) error) error {
+	var selector string
+
+	fs := newFlagSet(name, &selector)
+	_ = fs.Parse(args) // Exits on error.
+
+	return c.updateSelectedNodes(name, fs, selector, f)
+}
+
+// updateSelectedNodes updates nodes selected by names given as arguments or by the selector using f.
+func (c *cli) updateSelectedNodes(name string, fs *flag.FlagSet, selector string,
+	f func(corev1.Node) error) error {
+	nodes, err := c.selectNodesToUpdate(fs.Args(), selector)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !managed(node) {
+			return fmt.Errorf("node %q is not managed by update-agent", node.Name)
+		}
+
+		if err := f(node); err != nil {
+			return fmt.Errorf("updating node %q: %w", node.Name, err)
+		}
+
+		fmt.Fprintf(c.out, "node/%s: %s done\n", node.Name, name)
+	}
+
+	return nil
+}
+
+// why explains why the node is not rebooting yet.
+func (c *cli) why(args []string) error {
+	fs := flag.NewFlagSet("why", flag.ExitOnError)
+	_ = fs.Parse(args) // Exits on error.
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("exactly one node name must be given")
+	}
+
+	node, err := c.selectNodes(fs.Args(), "")
+	if err != nil {
+		return err
+	}
+
+	// Other nodes affect reboots of the node as well.
+	nodes, err := c.selectNodes(nil, "")
+	if err != nil {
+		return err
+	}
+
+	config, err := c.operatorConfig()
+	if err != nil {
+		return fmt.Errorf("getting update-operator configuration: %w", err)
+	}
+
+	reasons, err := operator.ExplainNode(node[0], nodes, config, time.Now())
+	if err != nil {
+		return fmt.Errorf("explaining node %q: %w", node[0].Name, err)
+	}
+
+	fmt.Fprintf(c.out, "Node %q is in phase %q:\n", node[0].Name, operator.NodePhase(node[0]))
+
+	for _, reason := range reasons {
+		fmt.Fprintf(c.out, "- %s\n", reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+//nolint:funlen // Just many test cases.
+func Test_phaseAnnotations(t *testing.T) {
+	t.Parallel()
+
+	beforeReboot := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{constants.LabelBeforeReboot: constants.True},
+			Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.True},
+		},
+	}
+
+	afterReboot := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{constants.LabelAfterReboot: constants.True},
+			Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.False},
+		},
+	}
+
+	rebootNeeded := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.True},
+		},
+	}
+
+	config := &updatev1alpha1.UpdatePolicySpec{
+		BeforeRebootAnnotations: []string{"before-a", "before-b"},
+		AfterRebootAnnotations:  []string{"after-a"},
+	}
+
+	cases := map[string]struct {
+		node        corev1.Node
+		config      *updatev1alpha1.UpdatePolicySpec
+		annotations string
+		expected    []string
+		expectError bool
+	}{
+		"configured_before_reboot_annotations": {
+			node:     beforeReboot,
+			config:   config,
+			expected: []string{"before-a", "before-b"},
+		},
+		"configured_after_reboot_annotations": {
+			node:     afterReboot,
+			config:   config,
+			expected: []string{"after-a"},
+		},
+		"explicit_annotations_without_configuration": {
+			node:        beforeReboot,
+			annotations: "a,b",
+			expected:    []string{"a", "b"},
+		},
+		"explicit_annotations_replace_configured_ones": {
+			node:        afterReboot,
+			config:      config,
+			annotations: "a",
+			expected:    []string{"a"},
+		},
+		"no_annotations_configured_for_phase": {
+			node:        afterReboot,
+			config:      &updatev1alpha1.UpdatePolicySpec{BeforeRebootAnnotations: []string{"before-a"}},
+			expectError: true,
+		},
+		"node_not_running_checks": {
+			node:        rebootNeeded,
+			config:      config,
+			annotations: "a",
+			expectError: true,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			annotations, err := phaseAnnotations(c.node, c.config, c.annotations)
+			if c.expectError {
+				if err == nil {
+					t.Fatalf("Expected error, got annotations %v", annotations)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Getting annotations: %v", err)
+			}
+
+			if !reflect.DeepEqual(annotations, c.expected) {
+				t.Fatalf("Expected annotations %v, got %v", c.expected, annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+// fluoctl inspects and controls updates of nodes managed by the update-operator and update-agent,
+// using the labels and annotations they set on nodes.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/version"
+)
+
+// updatePolicyPath is the API path of UpdatePolicy resources.
+var updatePolicyPath = path.Join("/apis", updatev1alpha1.SchemeGroupVersion.String(),
+	updatev1alpha1.UpdatePolicyResource)
+
+// command is a fluoctl subcommand.
+type command struct {
+	usage       string
+	description string
+	run         func(c *cli, args []string) error
+}
+
+// commands returns all fluoctl subcommands, by their name.
+func commands() map[string]command {
+	return map[string]command{
+		"status": {
+			usage:       "[-selector <selector>] [<node>...]",
+			description: "Show phase, versions and update_engine status of managed nodes",
+			run:         (*cli).status,
+		},
+		"pause": {
+			usage:       "[-selector <selector>] [<node>...]",
+			description: "Prevent nodes from being chosen for reboot",
+			run:         (*cli).pause,
+		},
+		"resume": {
+			usage:       "[-selector <selector>] [<node>...]",
+			description: "Allow paused nodes to be chosen for reboot again",
+			run:         (*cli).resume,
+		},
+		"approve": {
+			usage:       "[-annotations <annotations>] [-selector <selector>] [<node>...]",
+			description: "Set before-reboot or after-reboot annotations of nodes to \"true\"",
+			run:         (*cli).approve,
+		},
+		"reject": {
+			usage:       "[-annotations <annotations>] [-selector <selector>] [<node>...]",
+			description: "Set before-reboot or after-reboot annotations of nodes to \"false\", aborting the reboot",
+			run:         (*cli).reject,
+		},
+		"why": {
+			usage:       "<node>",
+			description: "Explain why the node is not rebooting yet",
+			run:         (*cli).why,
+		},
+	}
+}
+
+// cli holds the state shared by all subcommands.
+type cli struct {
+	kc           kubernetes.Interface
+	updatePolicy string
+	out          io.Writer
+}
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", "",
+		"Path to a kubeconfig file. Defaults to the KUBECONFIG environment variable or ~/.kube/config")
+	updatePolicy := flag.String("update-policy", "",
+		"Name of the UpdatePolicy used by the update-operator, which reports the operator configuration. "+
+			"Defaults to the only UpdatePolicy with reported configuration. If the update-operator does not use "+
+			"an UpdatePolicy, its configuration is unknown, so 'why' does not check it and 'approve' and "+
+			"'reject' require -annotations")
+	printVersion := flag.Bool("version", false, "Print version and exit")
+
+	flag.Usage = usage
+
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Println(version.Format())
+		os.Exit(0)
+	}
+
+	cmd, ok := commands()[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2) //nolint:mnd // Same exit code as for invalid flags.
+	}
+
+	kc, err := newClient(*kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: creating Kubernetes client: %v\n", err)
+		os.Exit(1)
+	}
+
+	c := &cli{
+		kc:           kc,
+		updatePolicy: *updatePolicy,
+		out:          os.Stdout,
+	}
+
+	if err := cmd.run(c, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage prints usage of fluoctl and all its subcommands.
+func usage() {
+	cmds := commands()
+	names := []string{}
+
+	for name := range cmds {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, cmds[name].usage, cmds[name].description)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// newClient creates Kubernetes client using the given kubeconfig file or using the default
+// kubeconfig loading rules, like kubectl.
+func newClient(kubeconfig string) (kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).
+		ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+
+	return kubernetes.NewForConfig(config) //nolint:wrapcheck // No context to add.
+}
+
+// newFlagSet creates flags of the given subcommand, with the -selector flag for selecting nodes.
+func newFlagSet(name string, selector *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	fs.StringVar(selector, "selector", "", "Label selector for nodes, e.g. 'node.kubernetes.io/pool=gpu'")
+	fs.StringVar(selector, "l", "", "Shorthand for -selector")
+
+	fs.Usage = func() {
+		cmd := commands()[name]
+
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n\n%s.\n\nFlags:\n", os.Args[0], name, cmd.usage, cmd.description)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// selectNodes returns nodes with the given names and nodes matching the given label selector.
+// If neither names nor the selector is given, all nodes are returned.
+func (c *cli) selectNodes(names []string, selector string) ([]corev1.Node, error) {
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("parsing selector %q: %w", selector, err)
+	}
+
+	if len(names) == 0 {
+		nodes, err := c.kc.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("listing nodes: %w", err)
+		}
+
+		return nodes.Items, nil
+	}
+
+	if selector != "" {
+		return nil, fmt.Errorf("node names and selector are mutually exclusive")
+	}
+
+	nodes := []corev1.Node{}
+
+	for _, name := range names {
+		node, err := c.kc.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting node %q: %w", name, err)
+		}
+
+		nodes = append(nodes, *node)
+	}
+
+	return nodes, nil
+}
+
+// selectNodesToUpdate returns nodes selected like selectNodes, but requires either names or
+// the selector to be given, so all nodes are never updated by accident.
+func (c *cli) selectNodesToUpdate(names []string, selector string) ([]corev1.Node, error) {
+	if len(names) == 0 && selector == "" {
+		return nil, fmt.Errorf("either node names or -selector must be given")
+	}
+
+	return c.selectNodes(names, selector)
+}
+
+// operatorConfig returns configuration of the update-operator reported in the UpdatePolicy status.
+// If the configuration is not reported, nil is returned.
+func (c *cli) operatorConfig() (*updatev1alpha1.UpdatePolicySpec, error) {
+	if c.updatePolicy != "" {
+		data, err := c.kc.Discovery().RESTClient().Get().AbsPath(updatePolicyPath, c.updatePolicy).
+			Do(context.TODO()).Raw()
+		if err != nil {
+			return nil, fmt.Errorf("getting UpdatePolicy %q: %w", c.updatePolicy, err)
+		}
+
+		policy := &updatev1alpha1.UpdatePolicy{}
+
+		if err := json.Unmarshal(data, policy); err != nil {
+			return nil, fmt.Errorf("decoding UpdatePolicy %q: %w", c.updatePolicy, err)
+		}
+
+		return policy.Status.ObservedConfiguration, nil
+	}
+
+	data, err := c.kc.Discovery().RESTClient().Get().AbsPath(updatePolicyPath).Do(context.TODO()).Raw()
+	if errors.IsNotFound(err) {
+		// UpdatePolicy resource is not installed.
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("listing UpdatePolicies: %w", err)
+	}
+
+	policies := &updatev1alpha1.UpdatePolicyList{}
+
+	if err := json.Unmarshal(data, policies); err != nil {
+		return nil, fmt.Errorf("decoding UpdatePolicies: %w", err)
+	}
+
+	var config *updatev1alpha1.UpdatePolicySpec
+
+	observed := []string{}
+
+	for _, policy := range policies.Items {
+		if policy.Status.ObservedConfiguration == nil {
+			continue
+		}
+
+		config = policy.Status.ObservedConfiguration
+		observed = append(observed, policy.Name)
+	}
+
+	if len(observed) > 1 {
+		return nil, fmt.Errorf("configuration is reported in multiple UpdatePolicies (%s), select one "+
+			"using -update-policy", strings.Join(observed, ", "))
+	}
+
+	return config, nil
+}
+
+// managed returns true if the node is managed by the update-agent. The agent sets
+// constants.AnnotationRebootNeeded annotation when it starts.
+func managed(node corev1.Node) bool {
+	_, ok := node.Annotations[constants.AnnotationRebootNeeded]
+
+	return ok
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+//nolint:funlen // Just many test cases.
+func Test_selecting_nodes(t *testing.T) {
+	t.Parallel()
+
+	node := func(name, pool string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"pool": pool},
+			},
+		}
+	}
+
+	c := &cli{
+		kc:  fake.NewSimpleClientset(node("a", "gpu"), node("b", "gpu"), node("c", "web")),
+		out: io.Discard,
+	}
+
+	cases := map[string]struct {
+		names    []string
+		selector string
+		// Nodes are only updated when selected explicitly.
+		update      bool
+		expected    []string
+		expectError bool
+	}{
+		"all_nodes_without_names_and_selector": {
+			expected: []string{"a", "b", "c"},
+		},
+		"nodes_matching_selector": {
+			selector: "pool=gpu",
+			expected: []string{"a", "b"},
+		},
+		"nodes_with_given_names": {
+			names:    []string{"c", "a"},
+			expected: []string{"c", "a"},
+		},
+		"names_and_selector_are_mutually_exclusive": {
+			names:       []string{"a"},
+			selector:    "pool=gpu",
+			expectError: true,
+		},
+		"unknown_node": {
+			names:       []string{"d"},
+			expectError: true,
+		},
+		"invalid_selector": {
+			selector:    "pool in",
+			expectError: true,
+		},
+		"nodes_to_update_matching_selector": {
+			selector: "pool=web",
+			update:   true,
+			expected: []string{"c"},
+		},
+		"nodes_to_update_require_names_or_selector": {
+			update:      true,
+			expectError: true,
+		},
+	}
+
+	for name, tc := range cases {
+		name, tc := name, tc
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			selectNodes := c.selectNodes
+			if tc.update {
+				selectNodes = c.selectNodesToUpdate
+			}
+
+			nodes, err := selectNodes(tc.names, tc.selector)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("Expected error, got %d nodes", len(nodes))
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Selecting nodes: %v", err)
+			}
+
+			names := []string{}
+
+			for _, n := range nodes {
+				names = append(names, n.Name)
+			}
+
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("Expected nodes %v, got %v", tc.expected, names)
+			}
+		})
+	}
+}
@@ -3,16 +3,22 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/coreos/pkg/flagutil"
 	"k8s.io/klog/v2"
 
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/agent"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/version"
 )
 
+// serverTimeout is a timeout for reading requests and writing responses of the metrics and health server.
+const serverTimeout = 10 * time.Second
+
 var (
 	node         = flag.String("node", "", "Kubernetes node name")
 	printVersion = flag.Bool("version", false, "Print version and exit")
@@ -21,6 +27,25 @@ var (
 		"Period of time in seconds given to a pod to terminate when rebooting for an update")
 	rebootWait = flag.Int("reboot-wait", 0,
 		"Period of time in seconds waiting after last pod deletion for reboot")
+	drainTimeout = flag.Duration("drain-timeout", 0,
+		"Maximum time to wait for pods to be evicted from the node before rebooting. Zero means no timeout")
+	drainTimeoutPolicy = flag.String("drain-timeout-policy", agent.DrainTimeoutPolicyAbort,
+		fmt.Sprintf("What to do when pods could not be evicted within --drain-timeout. Either %q to make the "+
+			"node schedulable again and retry the reboot later, or %q to delete remaining pods, bypassing "+
+			"PodDisruptionBudgets", agent.DrainTimeoutPolicyAbort, agent.DrainTimeoutPolicyForce))
+	drainExcludedNamespaces = flag.String("drain-excluded-namespaces",
+		strings.Join(k8sutil.DefaultDrainExcludedNamespaces, ","),
+		"Comma-separated list of namespaces whose pods are not evicted when draining the node")
+	drainExcludedPodSelector = flag.String("drain-excluded-pod-selector", "",
+		"Label selector for pods which are not evicted when draining the node")
+	drainRefuseLocalStorage = flag.Bool("drain-refuse-local-storage", false,
+		"Refuse to drain the node and abort the reboot when any pod uses local storage (emptyDir volumes), "+
+			"unless the pod has the drain-allow-local-storage annotation set to true")
+	drainRefuseUnmanagedPods = flag.Bool("drain-refuse-unmanaged-pods", false,
+		"Refuse to drain the node and abort the reboot when any pod is not managed by a controller")
+	metricsAddress = flag.String("metrics-address", ":8080",
+		"Address on which metrics in the Prometheus format and health are served on the /metrics and /healthz "+
+			"paths. Empty value disables the server")
 )
 
 func main() {
@@ -49,11 +74,25 @@ func main() {
 	rw := time.Duration(*rebootWait) * time.Second
 
 	klog.Infof("Waiting %v for reboot", rw)
-	a, err := agent.New(*node, rt, rw)
+	a, err := agent.New(agent.Config{
+		NodeName:                 *node,
+		PodDeletionGracePeriod:   rt,
+		RebootWait:               rw,
+		DrainTimeout:             *drainTimeout,
+		DrainTimeoutPolicy:       *drainTimeoutPolicy,
+		DrainExcludedNamespaces:  splitList(*drainExcludedNamespaces),
+		DrainExcludedPodSelector: *drainExcludedPodSelector,
+		DrainRefuseLocalStorage:  *drainRefuseLocalStorage,
+		DrainRefuseUnmanagedPods: *drainRefuseUnmanagedPods,
+	})
 	if err != nil {
 		klog.Fatalf("Failed to initialize %s: %v", os.Args[0], err)
 	}
 
+	if *metricsAddress != "" {
+		go serve(*metricsAddress, a)
+	}
+
 	klog.Infof("%s running", os.Args[0])
 
 	// Run agent until the stop channel is closed
@@ -61,3 +100,45 @@ func main() {
 	defer close(stop)
 	a.Run(stop)
 }
+
+// serve serves metrics and health of the given agent on the given address.
+func serve(address string, a *agent.Klocksmith) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.MetricsHandler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		if err := a.Healthy(); err != nil {
+			klog.Errorf("Health check failed: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		ReadTimeout:  serverTimeout,
+		WriteTimeout: serverTimeout,
+	}
+
+	klog.Infof("Serving metrics and health on %q", address)
+
+	if err := server.ListenAndServe(); err != nil {
+		klog.Fatalf("Failed serving metrics and health: %v", err)
+	}
+}
+
+// splitList splits given comma-separated list, dropping empty elements.
+func splitList(s string) []string {
+	list := []string{}
+
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
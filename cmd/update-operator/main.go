@@ -1,26 +1,79 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	// Embed time zone database, as container image does not include it and
+	// reboot windows are evaluated in the configured time zones.
+	_ "time/tzdata"
 
 	"github.com/coreos/pkg/flagutil"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/operator"
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/version"
 )
 
+const (
+	// poolRebootWindowParts is a number of parts of the pool reboot windows specification.
+	poolRebootWindowParts = 2
+
+	// metricsServerTimeout is a timeout for reading requests and writing responses of the metrics server.
+	metricsServerTimeout = 10 * time.Second
+)
+
 type flags struct {
-	beforeRebootAnnotations flagutil.StringSliceFlag
-	afterRebootAnnotations  flagutil.StringSliceFlag
-	kubeconfig              *string
-	autoLabelContainerLinux *bool
-	rebootWindowStart       *string
-	rebootWindowLength      *string
-	printVersion            *bool
+	beforeRebootAnnotations   flagutil.StringSliceFlag
+	afterRebootAnnotations    flagutil.StringSliceFlag
+	kubeconfig                *string
+	autoLabelContainerLinux   *bool
+	rebootWindowStart         *string
+	rebootWindowLength        *string
+	rebootWindows             *string
+	poolRebootWindows         *string
+	maxUnavailable            *string
+	topologyKey               *string
+	maxUnavailablePerTopology *string
+	canarySelector            *string
+	canaryCount               *int
+	canarySoakPeriod          *time.Duration
+	rebootDeadline            *time.Duration
+	rebootAbortBackoff        *time.Duration
+	drainRefusedBackoff       *time.Duration
+	blackoutConfigMap         *string
+	drainExcludedNamespaces   *string
+	drainExcludedPodSelector  *string
+	nodeOrdering              *string
+	controlPlaneSelector      *string
+	controlPlaneRebootWindows *string
+	beforeRebootJobTemplate   *string
+	afterRebootJobTemplate    *string
+	beforeRebootWebhook       *string
+	afterRebootWebhook        *string
+	webhookTimeout            *time.Duration
+	beforeRebootTimeout       *time.Duration
+	afterRebootTimeout        *time.Duration
+	updatePolicy              *string
+	nodeUpdates               *bool
+	leaderElectionLock        *string
+	leaderElectionNamespace   *string
+	leaderElectionLease       *time.Duration
+	leaderElectionRenew       *time.Duration
+	leaderElectionRetry       *time.Duration
+	metricsAddress            *string
+	dryRun                    *bool
+	printVersion              *bool
 }
 
 func handleFlags() *flags {
@@ -36,7 +89,140 @@ func handleFlags() *flags {
 				"E.g. 'Mon 14:00', '11:00'"),
 
 		rebootWindowLength: flag.String("reboot-window-length", "", "Length of the reboot window. E.g. '1h30m'"),
-		printVersion:       flag.Bool("version", false, "Print version and exit"),
+
+		rebootWindows: flag.String("reboot-windows", "",
+			"List of semicolon-separated reboot windows in format '[<days>] <time of day> <length> <time zone>'. "+
+				"E.g. 'Mon-Fri 22:00 4h Europe/Berlin; Sat,Sun 20:00 12h Europe/Berlin'"),
+
+		poolRebootWindows: flag.String("pool-reboot-windows", "",
+			"List of '|'-separated node pools with their own reboot windows in format "+
+				"'<node label selector>: <reboot windows>'. E.g. 'pool=gpu: Sat 02:00 6h UTC | pool=web: 03:00 1h UTC'"),
+
+		maxUnavailable: flag.String("max-unavailable", "1",
+			"Maximum number of nodes which may be rebooting or not ready at the same time. Either absolute "+
+				"number or a percentage of managed nodes. E.g. '3', '10%'"),
+
+		topologyKey: flag.String("topology-key", "",
+			"Node label key grouping nodes into topology domains with separate reboot limits. "+
+				"E.g. 'topology.kubernetes.io/zone'"),
+
+		maxUnavailablePerTopology: flag.String("max-unavailable-per-topology", "1",
+			"Maximum number of nodes which may be rebooting at the same time within a single topology domain. "+
+				"Either absolute number or a percentage of managed nodes in the domain. Only used with --topology-key"),
+
+		canarySelector: flag.String("canary-selector", "",
+			"Label selector for canary nodes, which are rebooted before any other node. E.g. 'canary=true'"),
+
+		canaryCount: flag.Int("canary-count", 0,
+			"Number of managed nodes, ordered by name, which are rebooted before any other node. "+
+				"Mutually exclusive with --canary-selector"),
+
+		canarySoakPeriod: flag.Duration("canary-soak-period", time.Hour,
+			"Time canary nodes must run a new version successfully before other nodes may reboot into it"),
+
+		rebootDeadline: flag.Duration("reboot-deadline", 0,
+			"Time in which a node must come back ready after update-agent started draining and rebooting it. "+
+				"Otherwise all further reboots are halted until an administrator clears the failure. "+
+				"Zero disables the check"),
+
+		rebootAbortBackoff: flag.Duration("reboot-abort-backoff", 10*time.Minute,
+			"Time after the reboot of a node has been aborted, e.g. due to failed before-reboot checks, during "+
+				"which the node is not chosen for reboot again. Zero disables the backoff"),
+
+		drainRefusedBackoff: flag.Duration("drain-refused-backoff", time.Hour,
+			"Time after update-agent refused to drain a node, during which the node is not chosen for reboot "+
+				"again. Only used when longer than --reboot-abort-backoff"),
+
+		blackoutConfigMap: flag.String("blackout-configmap", "flatcar-linux-update-operator-blackouts",
+			"Name of the ConfigMap in the operator namespace with blackout periods, during which no reboots are "+
+				"started. Empty value disables blackout periods"),
+
+		drainExcludedNamespaces: flag.String("drain-excluded-namespaces",
+			strings.Join(k8sutil.DefaultDrainExcludedNamespaces, ","),
+			"Comma-separated list of namespaces whose pods are not evicted when draining nodes, ignored when "+
+				"checking PodDisruptionBudgets. Must match the same update-agent flag"),
+
+		drainExcludedPodSelector: flag.String("drain-excluded-pod-selector", "",
+			"Label selector for pods which are not evicted when draining nodes, ignored when checking "+
+				"PodDisruptionBudgets. Must match the same update-agent flag"),
+
+		nodeOrdering: flag.String("node-ordering", operator.NodeOrderingNone,
+			"Order in which nodes are considered for rebooting. One of: "+
+				"'none' (order returned by the API server), "+
+				"'fewest-pods' (nodes with fewest non-DaemonSet pods first), "+
+				"'oldest-version' (nodes running oldest version first), "+
+				"'longest-waiting' (nodes waiting for reboot the longest first), "+
+				"'priority' (nodes with highest reboot-priority annotation first)"),
+
+		controlPlaneSelector: flag.String("control-plane-selector", operator.DefaultControlPlaneSelector,
+			"Label selector for control plane nodes, which are rebooted one at a time, never together with other "+
+				"nodes and only when no worker node is rebootable at the time"),
+
+		controlPlaneRebootWindows: flag.String("control-plane-reboot-windows", "",
+			"List of semicolon-separated reboot windows for control plane nodes, in the same format as "+
+				"--reboot-windows. Control plane nodes reboot only inside these windows"),
+
+		beforeRebootJobTemplate: flag.String("before-reboot-job-template", "",
+			"Path to a YAML file with a Job, which is run on each node entering the before-reboot phase. "+
+				"The node is allowed to reboot once the Job succeeds"),
+
+		afterRebootJobTemplate: flag.String("after-reboot-job-template", "",
+			"Path to a YAML file with a Job, which is run on each node entering the after-reboot phase. "+
+				"The reboot is completed once the Job succeeds"),
+
+		beforeRebootWebhook: flag.String("before-reboot-webhook", "",
+			"URL of the webhook called for each node in the before-reboot phase. The node is allowed to reboot "+
+				"once the webhook answers 'allow'"),
+
+		afterRebootWebhook: flag.String("after-reboot-webhook", "",
+			"URL of the webhook called for each node in the after-reboot phase. The reboot is completed "+
+				"once the webhook answers 'allow'"),
+
+		webhookTimeout: flag.Duration("webhook-timeout", 10*time.Second, "Timeout for webhook requests"),
+
+		beforeRebootTimeout: flag.Duration("before-reboot-timeout", 0,
+			"Maximum time a node may wait for before-reboot annotations. On timeout, the reboot is aborted and "+
+				"the node is considered for reboot after other nodes. Zero means no timeout"),
+
+		afterRebootTimeout: flag.Duration("after-reboot-timeout", 0,
+			"Maximum time a node may wait for after-reboot annotations. On timeout, all further reboots are "+
+				"halted until an administrator clears the failure. Zero means no timeout"),
+
+		updatePolicy: flag.String("update-policy", "",
+			"Name of the cluster-scoped UpdatePolicy object overriding the configuration given by flags. "+
+				"Changes to the policy are applied without a restart. Empty value disables it"),
+
+		nodeUpdates: flag.Bool("node-updates", false,
+			"Maintain a NodeUpdate object reporting the state of the update for every node managed by the agent. "+
+				"Requires the NodeUpdate custom resource definition to be installed"),
+
+		leaderElectionLock: flag.String("leader-election-resource-lock", operator.DefaultLeaderElectionResourceLock,
+			"Type of the leader election lock. One of 'leases', 'configmapsleases' or 'configmaps'. To migrate "+
+				"from the ConfigMap lock, run all replicas with 'configmapsleases' before switching to 'leases'"),
+
+		leaderElectionNamespace: flag.String("leader-election-namespace", "",
+			"Namespace of the leader election lock. Defaults to the operator namespace"),
+
+		leaderElectionLease: flag.Duration("leader-election-lease-duration", 90*time.Second,
+			"Duration non-leader candidates wait after observing a leadership renewal before they try to "+
+				"acquire the leadership"),
+
+		leaderElectionRenew: flag.Duration("leader-election-renew-deadline", 60*time.Second,
+			"Duration the leader retries renewing the leadership before giving up. Must be less than the "+
+				"lease duration"),
+
+		leaderElectionRetry: flag.Duration("leader-election-retry-period", 30*time.Second,
+			"Duration candidates wait between attempts to acquire or renew the leadership"),
+
+		metricsAddress: flag.String("metrics-address", ":8080",
+			"Address on which metrics are served in the Prometheus format on the /metrics path. "+
+				"Empty value disables serving metrics"),
+
+		dryRun: flag.Bool("dry-run", false,
+			"Evaluate the reconciliation without updating nodes, only logging and emitting Events describing the "+
+				"node labels and annotations which would be set. Reboot Jobs are not created"),
+
+		printVersion: flag.Bool("version", false, "Print version and exit"),
 	}
 
 	flag.Var(&f.beforeRebootAnnotations, "before-reboot-annotations",
@@ -66,6 +252,79 @@ func handleFlags() *flags {
 	return f
 }
 
+// parsePoolRebootWindows parses list of node pools with their reboot windows in format
+// '<selector>: <window>[; <window>...] [| <selector>: <window>...]'.
+func parsePoolRebootWindows(s string) ([]operator.PoolRebootWindow, error) {
+	pools := []operator.PoolRebootWindow{}
+
+	for _, pool := range splitList(s, "|") {
+		// Split into selector and windows.
+		parts := strings.SplitN(pool, ":", poolRebootWindowParts)
+		if len(parts) != poolRebootWindowParts {
+			return nil, fmt.Errorf("expected format '<selector>: <reboot windows>', got %q", pool)
+		}
+
+		pools = append(pools, operator.PoolRebootWindow{
+			Selector: strings.TrimSpace(parts[0]),
+			Windows:  splitList(parts[1], ";"),
+		})
+	}
+
+	return pools, nil
+}
+
+// loadJobTemplate loads Job template from the given YAML file. If path is empty, nil is returned.
+func loadJobTemplate(path string) (*batchv1.Job, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %q: %w", path, err)
+	}
+
+	job := &batchv1.Job{}
+
+	if err := yaml.UnmarshalStrict(data, job); err != nil {
+		return nil, fmt.Errorf("decoding Job from file %q: %w", path, err)
+	}
+
+	return job, nil
+}
+
+// splitList splits given string using given separator, dropping empty elements.
+func splitList(s, sep string) []string {
+	list := []string{}
+
+	for _, e := range strings.Split(s, sep) {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
+
+// serveMetrics serves metrics from the given handler on the given address.
+func serveMetrics(address string, handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	server := &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		ReadTimeout:  metricsServerTimeout,
+		WriteTimeout: metricsServerTimeout,
+	}
+
+	klog.Infof("Serving metrics on %q", address)
+
+	if err := server.ListenAndServe(); err != nil {
+		klog.Fatalf("Failed serving metrics: %v", err)
+	}
+}
+
 func main() {
 	f := handleFlags()
 
@@ -80,26 +339,110 @@ func main() {
 		klog.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
 
+	dynamicClient, err := k8sutil.GetDynamicClient(*f.kubeconfig)
+	if err != nil {
+		klog.Fatalf("Failed to create dynamic Kubernetes client: %v", err)
+	}
+
+	poolRebootWindows, err := parsePoolRebootWindows(*f.poolRebootWindows)
+	if err != nil {
+		klog.Fatalf("Failed to parse pool reboot windows: %v", err)
+	}
+
+	nodeOrdering, err := operator.NewNodeOrdering(*f.nodeOrdering, client)
+	if err != nil {
+		klog.Fatalf("Failed to configure node ordering: %v", err)
+	}
+
+	beforeRebootJobTemplate, err := loadJobTemplate(*f.beforeRebootJobTemplate)
+	if err != nil {
+		klog.Fatalf("Failed to load before-reboot Job template: %v", err)
+	}
+
+	afterRebootJobTemplate, err := loadJobTemplate(*f.afterRebootJobTemplate)
+	if err != nil {
+		klog.Fatalf("Failed to load after-reboot Job template: %v", err)
+	}
+
 	// Construct update-operator.
 	o, err := operator.New(operator.Config{
-		Client:                  client,
-		AutoLabelContainerLinux: *f.autoLabelContainerLinux,
-		BeforeRebootAnnotations: f.beforeRebootAnnotations,
-		AfterRebootAnnotations:  f.afterRebootAnnotations,
-		RebootWindowStart:       *f.rebootWindowStart,
-		RebootWindowLength:      *f.rebootWindowLength,
+		Client:                      client,
+		DynamicClient:               dynamicClient,
+		AutoLabelContainerLinux:     *f.autoLabelContainerLinux,
+		BeforeRebootAnnotations:     f.beforeRebootAnnotations,
+		AfterRebootAnnotations:      f.afterRebootAnnotations,
+		RebootWindowStart:           *f.rebootWindowStart,
+		RebootWindowLength:          *f.rebootWindowLength,
+		RebootWindows:               splitList(*f.rebootWindows, ";"),
+		PoolRebootWindows:           poolRebootWindows,
+		MaxUnavailable:              *f.maxUnavailable,
+		TopologyKey:                 *f.topologyKey,
+		MaxUnavailablePerTopology:   *f.maxUnavailablePerTopology,
+		CanarySelector:              *f.canarySelector,
+		CanaryCount:                 *f.canaryCount,
+		CanarySoakPeriod:            *f.canarySoakPeriod,
+		RebootDeadline:              *f.rebootDeadline,
+		RebootAbortBackoff:          *f.rebootAbortBackoff,
+		DrainRefusedBackoff:         *f.drainRefusedBackoff,
+		BlackoutConfigMap:           *f.blackoutConfigMap,
+		DrainExcludedNamespaces:     splitList(*f.drainExcludedNamespaces, ","),
+		DrainExcludedPodSelector:    *f.drainExcludedPodSelector,
+		NodeOrdering:                nodeOrdering,
+		ControlPlaneSelector:        *f.controlPlaneSelector,
+		ControlPlaneRebootWindows:   splitList(*f.controlPlaneRebootWindows, ";"),
+		BeforeRebootJobTemplate:     beforeRebootJobTemplate,
+		AfterRebootJobTemplate:      afterRebootJobTemplate,
+		BeforeRebootWebhook:         *f.beforeRebootWebhook,
+		AfterRebootWebhook:          *f.afterRebootWebhook,
+		WebhookTimeout:              *f.webhookTimeout,
+		BeforeRebootTimeout:         *f.beforeRebootTimeout,
+		AfterRebootTimeout:          *f.afterRebootTimeout,
+		UpdatePolicy:                *f.updatePolicy,
+		NodeUpdates:                 *f.nodeUpdates,
+		LeaderElectionResourceLock:  *f.leaderElectionLock,
+		LeaderElectionNamespace:     *f.leaderElectionNamespace,
+		LeaderElectionLeaseDuration: *f.leaderElectionLease,
+		LeaderElectionRenewDeadline: *f.leaderElectionRenew,
+		LeaderElectionRetryPeriod:   *f.leaderElectionRetry,
+		DryRun:                      *f.dryRun,
 	})
 	if err != nil {
 		klog.Fatalf("Failed to initialize %s: %v", os.Args[0], err)
 	}
 
+	if *f.metricsAddress != "" {
+		go serveMetrics(*f.metricsAddress, o.MetricsHandler())
+	}
+
 	klog.Infof("%s running", os.Args[0])
 
-	// Run operator until the stop channel is closed.
-	stop := make(chan struct{})
-	defer close(stop)
+	// Run operator until terminated.
+	ctx, cancel := signalContext()
+	defer cancel()
 
-	if err := o.Run(stop); err != nil {
+	if err := o.Run(ctx); err != nil {
 		klog.Fatalf("Error while running %s: %v", os.Args[0], err)
 	}
+
+	klog.Infof("%s stopped", os.Args[0])
+}
+
+// signalContext returns a context cancelled when the process receives SIGINT or SIGTERM. On the
+// second signal, the process exits immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		klog.Infof("Received %s, shutting down", sig)
+		cancel()
+
+		sig = <-signals
+		klog.Fatalf("Received %s again, exiting", sig)
+	}()
+
+	return ctx, cancel
 }
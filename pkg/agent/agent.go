@@ -7,7 +7,6 @@ package agent
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/coreos/go-systemd/login1"
@@ -18,7 +17,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	watchtools "k8s.io/client-go/tools/watch"
 	"k8s.io/klog/v2"
 
@@ -29,18 +30,54 @@ import (
 
 // Klocksmith implements agent part of FLUO.
 type Klocksmith struct {
-	node        string
-	kc          kubernetes.Interface
-	nc          corev1client.NodeInterface
-	ue          *updateengine.Client
-	lc          *login1.Conn
-	reapTimeout time.Duration
-	rebootWait  time.Duration
+	node               string
+	kc                 kubernetes.Interface
+	nc                 corev1client.NodeInterface
+	er                 record.EventRecorder
+	ue                 *updateengine.Client
+	lc                 *login1.Conn
+	reapTimeout        time.Duration
+	rebootWait         time.Duration
+	drainTimeout       time.Duration
+	drainTimeoutPolicy string
+	drainExclusions    *k8sutil.DrainExclusions
+	drainPolicy        k8sutil.DrainPolicy
+	metrics            *agentMetrics
+
+	// Intervals of polling for deleted pods and of retrying refused evictions.
+	podDeletionPollInterval      time.Duration
+	evictionRetryInitialInterval time.Duration
+	evictionRetryMaxInterval     time.Duration
+}
+
+// Config configures Klocksmith.
+type Config struct {
+	// Name of the node the agent runs on.
+	NodeName string
+	// Period of time given to a pod to terminate when rebooting for an update.
+	PodDeletionGracePeriod time.Duration
+	// Period of time to wait after the drain, before rebooting.
+	RebootWait time.Duration
+	// Maximum time to wait for pods to be evicted. Zero means no timeout.
+	DrainTimeout time.Duration
+	// What to do with pods, which could not be evicted before DrainTimeout. Either
+	// DrainTimeoutPolicyAbort or DrainTimeoutPolicyForce. Defaults to DrainTimeoutPolicyAbort.
+	DrainTimeoutPolicy string
+	// Namespaces, whose pods are not evicted when draining the node, e.g. k8sutil.DefaultDrainExcludedNamespaces.
+	DrainExcludedNamespaces []string
+	// Label selector for pods, which are not evicted when draining the node.
+	DrainExcludedPodSelector string
+	// If true, the drain is refused when any pod uses local storage, unless the pod has
+	// constants.AnnotationDrainAllowLocalStorage annotation set to "true".
+	DrainRefuseLocalStorage bool
+	// If true, the drain is refused when any pod is not managed by a controller.
+	DrainRefuseUnmanagedPods bool
 }
 
 const (
 	defaultPollInterval     = 10 * time.Second
 	maxOperatorResponseTime = 24 * time.Hour
+	eventSourceComponent    = "update-agent"
 )
 
 var shouldRebootSelector = fields.Set(map[string]string{
@@ -49,7 +86,25 @@ var shouldRebootSelector = fields.Set(map[string]string{
 }).AsSelector()
 
 // New returns initialized Klocksmith.
-func New(node string, reapTimeout time.Duration, rebootWait time.Duration) (*Klocksmith, error) {
+func New(config Config) (*Klocksmith, error) {
+	if config.NodeName == "" {
+		return nil, fmt.Errorf("node name must be set")
+	}
+
+	drainTimeoutPolicy := config.DrainTimeoutPolicy
+	if drainTimeoutPolicy == "" {
+		drainTimeoutPolicy = DrainTimeoutPolicyAbort
+	}
+
+	if err := validateDrainTimeoutPolicy(drainTimeoutPolicy); err != nil {
+		return nil, fmt.Errorf("validating drain timeout policy: %w", err)
+	}
+
+	exclusions, err := k8sutil.NewDrainExclusions(config.DrainExcludedNamespaces, config.DrainExcludedPodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing drain exclusions: %w", err)
+	}
+
 	// Set up kubernetes in-cluster client.
 	kc, err := k8sutil.GetClient("")
 	if err != nil {
@@ -59,6 +114,14 @@ func New(node string, reapTimeout time.Duration, rebootWait time.Duration) (*Klo
 	// Node interface.
 	nc := kc.CoreV1().Nodes()
 
+	// Create event emitter.
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: kc.CoreV1().Events("")})
+	er := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: eventSourceComponent,
+		Host:      config.NodeName,
+	})
+
 	// Set up update_engine client.
 	ue, err := updateengine.New()
 	if err != nil {
@@ -71,7 +134,27 @@ func New(node string, reapTimeout time.Duration, rebootWait time.Duration) (*Klo
 		return nil, fmt.Errorf("error establishing connection to logind dbus: %w", err)
 	}
 
-	return &Klocksmith{node, kc, nc, ue, lc, reapTimeout, rebootWait}, nil
+	return &Klocksmith{
+		node:               config.NodeName,
+		kc:                 kc,
+		nc:                 nc,
+		er:                 er,
+		ue:                 ue,
+		lc:                 lc,
+		reapTimeout:        config.PodDeletionGracePeriod,
+		rebootWait:         config.RebootWait,
+		drainTimeout:       config.DrainTimeout,
+		drainTimeoutPolicy: drainTimeoutPolicy,
+		drainExclusions:    exclusions,
+		drainPolicy: k8sutil.DrainPolicy{
+			RefuseLocalStorage:  config.DrainRefuseLocalStorage,
+			RefuseUnmanagedPods: config.DrainRefuseUnmanagedPods,
+		},
+		metrics:                      newAgentMetrics(),
+		podDeletionPollInterval:      defaultPollInterval,
+		evictionRetryInitialInterval: defaultEvictionRetryInitialInterval,
+		evictionRetryMaxInterval:     defaultEvictionRetryMaxInterval,
+	}, nil
 }
 
 // Run starts the agent to listen for an update_engine reboot signal and react
@@ -81,7 +164,7 @@ func (k *Klocksmith) Run(stop <-chan struct{}) {
 
 	// Agent process should reboot the node, no need to loop.
 	if err := k.process(stop); err != nil {
-		klog.Errorf("Error running agent process: %w", err)
+		klog.Errorf("Error running agent process: %v", err)
 	}
 
 	klog.V(5).Info("Stopping agent")
@@ -156,6 +239,8 @@ func (k *Klocksmith) process(stop <-chan struct{}) error {
 	// Watch update engine for status updates.
 	go k.watchUpdateStatus(k.updateStatusCallback, stop)
 
+	waitStart := time.Now()
+
 	// Block until constants.AnnotationOkToReboot is set.
 	for {
 		klog.Infof("Waiting for ok-to-reboot from controller...")
@@ -166,9 +251,11 @@ func (k *Klocksmith) process(stop <-chan struct{}) error {
 			break
 		}
 
-		klog.Warningf("error waiting for an ok-to-reboot: %w", err)
+		klog.Warningf("error waiting for an ok-to-reboot: %v", err)
 	}
 
+	k.metrics.okToRebootWait.Observe(time.Since(waitStart).Seconds())
+
 	klog.Info("Checking if node is already unschedulable")
 
 	node, err = k8sutil.GetNodeRetry(k.nc, k.node)
@@ -180,7 +267,8 @@ func (k *Klocksmith) process(stop <-chan struct{}) error {
 
 	// Set constants.AnnotationRebootInProgress and drain self.
 	anno = map[string]string{
-		constants.AnnotationRebootInProgress: constants.True,
+		constants.AnnotationRebootInProgress:     constants.True,
+		constants.AnnotationRebootInProgressTime: time.Now().UTC().Format(time.RFC3339),
 	}
 
 	if !alreadyUnschedulable {
@@ -195,7 +283,7 @@ func (k *Klocksmith) process(stop <-chan struct{}) error {
 
 	// Drain self equates to:
 	// 1. set Unschedulable if necessary
-	// 2. delete all pods
+	// 2. evict all pods
 	// unlike `kubectl drain`, we do not care about emptyDir or orphan pods
 	// ('any pods that are neither mirror pods nor managed by
 	// ReplicationController, ReplicaSet, DaemonSet or Job').
@@ -210,46 +298,39 @@ func (k *Klocksmith) process(stop <-chan struct{}) error {
 		klog.Info("Node already marked as unschedulable")
 	}
 
-	klog.Info("Getting pod list for deletion")
+	klog.Info("Getting pod list for eviction")
 
 	pods, err := k.getPodsForDeletion()
 	if err != nil {
 		return err
 	}
 
-	// Delete the pods.
-	// TODO(mischief): explicitly don't terminate self? we'll probably just be a
-	// Mirror pod or daemonset anyway..
-	klog.Infof("Deleting %d pods", len(pods))
-
-	for _, pod := range pods {
-		klog.Infof("Terminating pod %q...", pod.Name)
+	if err := k8sutil.CheckDrainPolicy(pods, k.drainPolicy); err != nil {
+		return k.refuseDrain(node, err, !alreadyUnschedulable)
+	}
 
-		if err := k.kc.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}); err != nil {
-			// Continue anyways, the reboot should terminate it.
-			klog.Errorf("failed terminating pod %q: %v", pod.Name, err)
-		}
+	if err := k8sutil.DeleteNodeAnnotations(k.nc, k.node, []string{constants.AnnotationDrainRefused}); err != nil {
+		return fmt.Errorf("removing node %q annotations: %w", k.node, err)
 	}
 
-	// Wait for the pods to delete completely.
-	wg := sync.WaitGroup{}
+	// Evict the pods.
+	// TODO(mischief): explicitly don't terminate self? we'll probably just be a
+	// Mirror pod or daemonset anyway..
+	drainStart := time.Now()
+	err = k.drain(pods)
 
-	for _, pod := range pods {
-		wg.Add(1)
+	k.metrics.drainDuration.Observe(time.Since(drainStart).Seconds())
 
-		go func(pod corev1.Pod) {
-			klog.Infof("Waiting for pod %q to terminate", pod.Name)
+	if err != nil {
+		klog.Errorf("Draining node failed, aborting reboot: %v", err)
 
-			if err := k.waitForPodDeletion(pod); err != nil {
-				klog.Errorf("Skipping wait on pod %q: %v", pod.Name, err)
-			}
+		if err := k.abortReboot(!alreadyUnschedulable); err != nil {
+			return fmt.Errorf("aborting reboot: %w", err)
+		}
 
-			wg.Done()
-		}(pod)
+		return fmt.Errorf("draining node %q: %w", k.node, err)
 	}
 
-	wg.Wait()
-
 	// We wait a little bit more time to perform finalizing operations
 	// This solves problems with some storage provisioners like rook.
 	klog.Infof("Waiting for finalizing operations, waiting %v", k.rebootWait)
@@ -285,6 +366,7 @@ func (k *Klocksmith) updateStatusCallback(s updateengine.Status) {
 		klog.Info("Indicating a reboot is needed")
 
 		anno[constants.AnnotationRebootNeeded] = constants.True
+		anno[constants.AnnotationRebootNeededTime] = time.Now().UTC().Format(time.RFC3339)
 		labels[constants.LabelRebootNeeded] = constants.True
 	}
 
@@ -331,6 +413,8 @@ func (k *Klocksmith) watchUpdateStatus(update func(s updateengine.Status), stop
 	go k.ue.ReceiveStatuses(ch, stop)
 
 	for status := range ch {
+		k.metrics.observeStatus(oldOperation, status)
+
 		if status.CurrentOperation != oldOperation && update != nil {
 			update(status)
 			oldOperation = status.CurrentOperation
@@ -338,6 +422,15 @@ func (k *Klocksmith) watchUpdateStatus(update func(s updateengine.Status), stop
 	}
 }
 
+// Healthy returns an error if the D-Bus connection to update_engine is broken.
+func (k *Klocksmith) Healthy() error {
+	if err := k.ue.Ping(); err != nil {
+		return fmt.Errorf("pinging update_engine: %w", err)
+	}
+
+	return nil
+}
+
 // waitForOkToReboot waits for both 'ok-to-reboot' and 'needs-reboot' to be true.
 func (k *Klocksmith) waitForOkToReboot() error {
 	n, err := k.nc.Get(context.TODO(), k.node, metav1.GetOptions{})
@@ -438,26 +531,25 @@ func (k *Klocksmith) waitForNotOkToReboot() error {
 }
 
 func (k *Klocksmith) getPodsForDeletion() ([]corev1.Pod, error) {
-	pods, err := k8sutil.GetPodsForDeletion(k.kc, k.node)
+	// By default, ignoring kube-system is a simple way to avoid evicting
+	// critical components such as kube-scheduler and
+	// kube-controller-manager.
+	pods, err := k8sutil.GetPodsToEvict(k.kc, k.node, k.drainExclusions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get list of pods for deletion: %w", err)
 	}
 
-	// XXX: ignoring kube-system is a simple way to avoid eviciting
-	// critical components such as kube-scheduler and
-	// kube-controller-manager.
-
-	pods = k8sutil.FilterPods(pods, func(p *corev1.Pod) bool {
-		return p.Namespace != "kube-system"
-	})
-
 	return pods, nil
 }
 
-// waitForPodDeletion waits for a pod to be deleted.
-func (k *Klocksmith) waitForPodDeletion(pod corev1.Pod) error {
-	return wait.PollImmediate(defaultPollInterval, k.reapTimeout, func() (bool, error) {
-		p, err := k.kc.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+// waitForPodDeletion waits for a pod to be deleted, at most for the pod deletion grace period
+// or until the given context is done.
+func (k *Klocksmith) waitForPodDeletion(ctx context.Context, pod corev1.Pod) error {
+	ctx, cancel := watchtools.ContextWithOptionalTimeout(ctx, k.reapTimeout)
+	defer cancel()
+
+	return wait.PollImmediateUntil(k.podDeletionPollInterval, func() (bool, error) {
+		p, err := k.kc.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 		if errors.IsNotFound(err) || (p != nil && p.ObjectMeta.UID != pod.ObjectMeta.UID) {
 			klog.Infof("Deleted pod %q", pod.Name)
 
@@ -471,7 +563,7 @@ func (k *Klocksmith) waitForPodDeletion(pod corev1.Pod) error {
 		}
 
 		return false, nil
-	})
+	}, ctx.Done())
 }
 
 // sleepOrDone pauses the current goroutine until the done channel receives
@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+const (
+	// DrainTimeoutPolicyAbort makes the agent give up the reboot when the drain times out. The node is
+	// made schedulable again and the reboot slot is released, so the reboot can be retried later.
+	DrainTimeoutPolicyAbort = "abort"
+	// DrainTimeoutPolicyForce makes the agent delete pods, which could not be evicted before the drain
+	// timeout, bypassing PodDisruptionBudgets.
+	DrainTimeoutPolicyForce = "force"
+
+	// Default initial and maximum interval between retries of the eviction refused due to PodDisruptionBudget.
+	defaultEvictionRetryInitialInterval = 5 * time.Second
+	defaultEvictionRetryMaxInterval     = time.Minute
+)
+
+// validateDrainTimeoutPolicy returns an error if given drain timeout policy is not supported.
+func validateDrainTimeoutPolicy(policy string) error {
+	switch policy {
+	case DrainTimeoutPolicyAbort, DrainTimeoutPolicyForce:
+		return nil
+	default:
+		return fmt.Errorf("unknown drain timeout policy %q, supported values are: %q, %q", policy,
+			DrainTimeoutPolicyAbort, DrainTimeoutPolicyForce)
+	}
+}
+
+// drain evicts given pods using the Eviction API, which respects PodDisruptionBudgets, and waits
+// for them to terminate. Evictions refused due to PodDisruptionBudgets are retried with backoff.
+// The drain timeout applies to the whole drain, including waiting for evicted pods to terminate.
+//
+// Pods which could not be evicted or did not terminate before the drain timeout are handled according
+// to the drain timeout policy. With DrainTimeoutPolicyForce, they are deleted. With DrainTimeoutPolicyAbort,
+// an error is returned and the reboot should be aborted.
+func (k *Klocksmith) drain(pods []corev1.Pod) error {
+	ctx, cancel := watchtools.ContextWithOptionalTimeout(context.Background(), k.drainTimeout)
+	defer cancel()
+
+	klog.Infof("Evicting %d pods", len(pods))
+
+	var (
+		mu        sync.Mutex
+		remaining []corev1.Pod
+	)
+
+	wg := sync.WaitGroup{}
+
+	for _, pod := range pods {
+		wg.Add(1)
+
+		go func(pod corev1.Pod) {
+			defer wg.Done()
+
+			if err := k.evictAndWait(ctx, pod); err != nil && ctx.Err() != nil {
+				mu.Lock()
+				remaining = append(remaining, pod)
+				mu.Unlock()
+			}
+		}(pod)
+	}
+
+	wg.Wait()
+
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	if k.drainTimeoutPolicy == DrainTimeoutPolicyAbort {
+		return fmt.Errorf("draining %d pods did not finish within drain timeout %v", len(remaining), k.drainTimeout)
+	}
+
+	klog.Warningf("Draining %d pods did not finish within drain timeout %v, deleting them", len(remaining),
+		k.drainTimeout)
+
+	k.deletePods(remaining)
+
+	return nil
+}
+
+// evictAndWait evicts given pod and waits for it to terminate. Errors other than the context being
+// done are only logged, as the reboot should terminate the pod anyway.
+func (k *Klocksmith) evictAndWait(ctx context.Context, pod corev1.Pod) error {
+	if err := k.evictPod(ctx, pod); err != nil {
+		k.metrics.podEvictions.WithLabelValues(evictionResultFailed).Inc()
+
+		if ctx.Err() == nil {
+			// Continue anyways, the reboot should terminate it.
+			klog.Errorf("Failed evicting pod %q: %v", pod.Name, err)
+		}
+
+		return err
+	}
+
+	k.metrics.podEvictions.WithLabelValues(evictionResultEvicted).Inc()
+
+	klog.Infof("Waiting for pod %q to terminate", pod.Name)
+
+	if err := k.waitForPodDeletion(ctx, pod); err != nil {
+		klog.Errorf("Skipping wait on pod %q: %v", pod.Name, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// evictPod evicts given pod. If the eviction is refused because it would violate a PodDisruptionBudget,
+// it is retried with exponential backoff until the context is done.
+func (k *Klocksmith) evictPod(ctx context.Context, pod corev1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+
+	interval := k.evictionRetryInitialInterval
+
+	for {
+		klog.Infof("Evicting pod %q...", pod.Name)
+
+		err := k.kc.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+
+		switch {
+		case err == nil, errors.IsNotFound(err):
+			return nil
+		case errors.IsTooManyRequests(err):
+			klog.Infof("Eviction of pod %q refused, retrying in %v: %v", pod.Name, interval, err)
+		default:
+			return fmt.Errorf("evicting pod: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("evicting pod: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		if interval *= 2; interval > k.evictionRetryMaxInterval {
+			interval = k.evictionRetryMaxInterval
+		}
+	}
+}
+
+// deletePods deletes given pods, bypassing PodDisruptionBudgets, and waits for them to terminate.
+func (k *Klocksmith) deletePods(pods []corev1.Pod) {
+	klog.Infof("Deleting %d pods", len(pods))
+
+	for _, pod := range pods {
+		klog.Infof("Terminating pod %q...", pod.Name)
+
+		if err := k.kc.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}); err != nil {
+			// Continue anyways, the reboot should terminate it.
+			klog.Errorf("failed terminating pod %q: %v", pod.Name, err)
+
+			continue
+		}
+
+		k.metrics.podEvictions.WithLabelValues(evictionResultDeleted).Inc()
+	}
+
+	wg := sync.WaitGroup{}
+
+	for _, pod := range pods {
+		wg.Add(1)
+
+		go func(pod corev1.Pod) {
+			klog.Infof("Waiting for pod %q to terminate", pod.Name)
+
+			if err := k.waitForPodDeletion(context.Background(), pod); err != nil {
+				klog.Errorf("Skipping wait on pod %q: %v", pod.Name, err)
+			}
+
+			wg.Done()
+		}(pod)
+	}
+
+	wg.Wait()
+}
+
+// abortReboot gives up the reboot after the failed drain. The node is made schedulable again, if
+// it was made unschedulable by the agent, and annotations are reset. The reboot-aborted annotation
+// makes the operator release the reboot slot of the node, instead of treating the node as rebooted.
+// When the agent restarts, the update_engine status is reported again, so the node requests
+// the reboot again.
+func (k *Klocksmith) abortReboot(madeUnschedulable bool) error {
+	anno := map[string]string{
+		constants.AnnotationRebootInProgress: constants.False,
+		constants.AnnotationRebootNeeded:     constants.False,
+		constants.AnnotationRebootAborted:    constants.True,
+	}
+
+	if madeUnschedulable {
+		klog.Info("Marking node as schedulable")
+
+		if err := k8sutil.Unschedulable(k.nc, k.node, false); err != nil {
+			return fmt.Errorf("marking node %q as schedulable: %w", k.node, err)
+		}
+
+		anno[constants.AnnotationAgentMadeUnschedulable] = constants.False
+	}
+
+	labels := map[string]string{
+		constants.LabelRebootNeeded: constants.False,
+	}
+
+	klog.Infof("Setting annotations %#v", anno)
+
+	if err := k8sutil.SetNodeAnnotationsLabels(k.nc, k.node, anno, labels); err != nil {
+		return fmt.Errorf("setting node %q labels and annotations: %w", k.node, err)
+	}
+
+	return nil
+}
+
+// refuseDrain reports the reason why the drain has been refused on the node, using an annotation
+// and an Event, and aborts the reboot, so the reboot slot of the node is released.
+func (k *Klocksmith) refuseDrain(node *corev1.Node, reason error, madeUnschedulable bool) error {
+	klog.Errorf("Refusing to drain node, aborting reboot: %v", reason)
+
+	anno := map[string]string{
+		constants.AnnotationDrainRefused: reason.Error(),
+	}
+
+	if err := k8sutil.SetNodeAnnotations(k.nc, k.node, anno); err != nil {
+		return fmt.Errorf("setting node %q annotations: %w", k.node, err)
+	}
+
+	k.er.Eventf(node, corev1.EventTypeWarning, "DrainRefused", "Refusing to drain node, aborting reboot: %v", reason)
+
+	if err := k.abortReboot(madeUnschedulable); err != nil {
+		return fmt.Errorf("aborting reboot: %w", err)
+	}
+
+	return fmt.Errorf("draining node %q: %w", k.node, reason)
+}
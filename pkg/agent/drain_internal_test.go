@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+const testNode = "node"
+
+var podsResource = corev1.SchemeGroupVersion.WithResource("pods")
+
+func testPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: testNode},
+	}
+}
+
+func testKlocksmith(objects ...runtime.Object) (*Klocksmith, *fake.Clientset) {
+	kc := fake.NewSimpleClientset(objects...)
+
+	return &Klocksmith{
+		node:                         testNode,
+		kc:                           kc,
+		nc:                           kc.CoreV1().Nodes(),
+		er:                           record.NewFakeRecorder(10),
+		reapTimeout:                  time.Minute,
+		drainTimeoutPolicy:           DrainTimeoutPolicyAbort,
+		metrics:                      newAgentMetrics(),
+		podDeletionPollInterval:      10 * time.Millisecond,
+		evictionRetryInitialInterval: time.Millisecond,
+		evictionRetryMaxInterval:     10 * time.Millisecond,
+	}, kc
+}
+
+// evictionReactor handles evictions in the fake client. First refusals evictions are refused as if they
+// violated a PodDisruptionBudget, the rest deletes the pod, unless keepPods is true. Negative refusals
+// refuses all evictions. It returns a function returning number of eviction attempts.
+func evictionReactor(kc *fake.Clientset, refusals int, keepPods bool) func() int {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+
+	kc.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		mu.Lock()
+		attempts++
+		refuse := refusals < 0 || attempts <= refusals
+		mu.Unlock()
+
+		if refuse {
+			return true, nil, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption "+
+				"budget.", 0)
+		}
+
+		if keepPods {
+			return true, nil, nil
+		}
+
+		eviction, _ := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+
+		return true, nil, kc.Tracker().Delete(podsResource, eviction.Namespace, eviction.Name)
+	})
+
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return attempts
+	}
+}
+
+func remainingPods(t *testing.T, kc *fake.Clientset) int {
+	t.Helper()
+
+	pods, err := kc.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Listing pods: %v", err)
+	}
+
+	return len(pods.Items)
+}
+
+func Test_drain_evicts_pods_and_waits_for_their_termination(t *testing.T) {
+	t.Parallel()
+
+	k, kc := testKlocksmith(testPod("a"), testPod("b"))
+	evictionReactor(kc, 0, false)
+
+	if err := k.drain([]corev1.Pod{*testPod("a"), *testPod("b")}); err != nil {
+		t.Fatalf("Draining node: %v", err)
+	}
+
+	if n := remainingPods(t, kc); n != 0 {
+		t.Fatalf("Expected all pods to be evicted, %d pods remain", n)
+	}
+}
+
+func Test_evictPod_retries_evictions_refused_by_PodDisruptionBudget(t *testing.T) {
+	t.Parallel()
+
+	k, kc := testKlocksmith(testPod("a"))
+	attempts := evictionReactor(kc, 2, false)
+
+	if err := k.evictPod(context.Background(), *testPod("a")); err != nil {
+		t.Fatalf("Evicting pod: %v", err)
+	}
+
+	if n := attempts(); n != 3 {
+		t.Fatalf("Expected eviction to be attempted 3 times, got %d", n)
+	}
+}
+
+func Test_evictPod_uses_policy_v1_Eviction_API(t *testing.T) {
+	t.Parallel()
+
+	k, kc := testKlocksmith(testPod("a"))
+
+	var gvks []schema.GroupVersionKind
+
+	kc.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		// Real client sends the group and version of the object type, so take them from the scheme.
+		kinds, _, err := scheme.Scheme.ObjectKinds(action.(k8stesting.CreateAction).GetObject())
+		if err != nil {
+			return true, nil, err
+		}
+
+		gvks = kinds
+
+		return true, nil, nil
+	})
+
+	if err := k.evictPod(context.Background(), *testPod("a")); err != nil {
+		t.Fatalf("Evicting pod: %v", err)
+	}
+
+	expected := policyv1.SchemeGroupVersion.WithKind("Eviction")
+
+	if len(gvks) != 1 || gvks[0] != expected {
+		t.Fatalf("Expected eviction to be sent as %v, got %v", expected, gvks)
+	}
+}
+
+func Test_evictPod_stops_retrying_when_context_is_done(t *testing.T) {
+	t.Parallel()
+
+	k, kc := testKlocksmith(testPod("a"))
+	evictionReactor(kc, -1, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := k.evictPod(ctx, *testPod("a")); err == nil {
+		t.Fatalf("Expected error when eviction is refused until the context is done")
+	}
+}
+
+//nolint:funlen // Just many test cases.
+func Test_drain_applies_drain_timeout_policy(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		policy string
+		// Eviction is accepted, but the pod never terminates.
+		stuckTerminating bool
+		expectError      bool
+		expectedPods     int
+	}{
+		"abort_when_eviction_is_refused": {
+			policy:       DrainTimeoutPolicyAbort,
+			expectError:  true,
+			expectedPods: 1,
+		},
+		"abort_when_pod_does_not_terminate": {
+			policy:           DrainTimeoutPolicyAbort,
+			stuckTerminating: true,
+			expectError:      true,
+			expectedPods:     1,
+		},
+		"force_delete_when_eviction_is_refused": {
+			policy: DrainTimeoutPolicyForce,
+		},
+		"force_delete_when_pod_does_not_terminate": {
+			policy:           DrainTimeoutPolicyForce,
+			stuckTerminating: true,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			k, kc := testKlocksmith(testPod("a"))
+			k.drainTimeout = 50 * time.Millisecond
+			k.drainTimeoutPolicy = c.policy
+
+			refusals := -1
+			if c.stuckTerminating {
+				refusals = 0
+			}
+
+			evictionReactor(kc, refusals, c.stuckTerminating)
+
+			err := k.drain([]corev1.Pod{*testPod("a")})
+			if c.expectError && err == nil {
+				t.Fatalf("Expected drain to fail")
+			}
+
+			if !c.expectError && err != nil {
+				t.Fatalf("Draining node: %v", err)
+			}
+
+			if n := remainingPods(t, kc); n != c.expectedPods {
+				t.Fatalf("Expected %d pods to remain, got %d", c.expectedPods, n)
+			}
+		})
+	}
+}
+
+func Test_abortReboot_makes_node_schedulable_and_reports_abort(t *testing.T) {
+	t.Parallel()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   testNode,
+			Labels: map[string]string{},
+			Annotations: map[string]string{
+				constants.AnnotationOkToReboot:             constants.True,
+				constants.AnnotationRebootNeeded:           constants.True,
+				constants.AnnotationRebootInProgress:       constants.True,
+				constants.AnnotationAgentMadeUnschedulable: constants.True,
+			},
+		},
+		Spec: corev1.NodeSpec{Unschedulable: true},
+	}
+
+	k, kc := testKlocksmith(node)
+
+	if err := k.abortReboot(true); err != nil {
+		t.Fatalf("Aborting reboot: %v", err)
+	}
+
+	updated, err := kc.CoreV1().Nodes().Get(context.TODO(), testNode, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting node: %v", err)
+	}
+
+	if updated.Spec.Unschedulable {
+		t.Errorf("Expected node to be schedulable after aborted reboot")
+	}
+
+	for key, value := range map[string]string{
+		constants.AnnotationRebootAborted:          constants.True,
+		constants.AnnotationRebootNeeded:           constants.False,
+		constants.AnnotationRebootInProgress:       constants.False,
+		constants.AnnotationAgentMadeUnschedulable: constants.False,
+	} {
+		if updated.Annotations[key] != value {
+			t.Errorf("Expected annotation %q to be %q, got %q", key, value, updated.Annotations[key])
+		}
+	}
+}
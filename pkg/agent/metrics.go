@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/updateengine"
+)
+
+const (
+	metricsPrefix = "flatcar_linux_update_agent_"
+
+	// Results of pod evictions reported in metrics.
+	evictionResultEvicted = "evicted"
+	evictionResultFailed  = "failed"
+	evictionResultDeleted = "deleted"
+)
+
+// agentMetrics are metrics reported by the agent.
+type agentMetrics struct {
+	registry *prometheus.Registry
+
+	operationTransitions *prometheus.CounterVec
+	downloadProgress     prometheus.Gauge
+	drainDuration        prometheus.Histogram
+	podEvictions         *prometheus.CounterVec
+	okToRebootWait       prometheus.Histogram
+}
+
+// newAgentMetrics creates and registers metrics reported by the agent.
+func newAgentMetrics() *agentMetrics {
+	// From a second to a day and a half.
+	durationBuckets := prometheus.ExponentialBuckets(1, 2, 18) //nolint:mnd // Explained above.
+
+	m := &agentMetrics{
+		registry: prometheus.NewRegistry(),
+		operationTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricsPrefix + "update_engine_operation_transitions_total",
+			Help: "Number of transitions between update_engine operations.",
+		}, []string{"from", "to"}),
+		downloadProgress: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: metricsPrefix + "update_engine_download_progress",
+			Help: "Progress of the update download reported by update_engine, from 0 to 1.",
+		}),
+		drainDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricsPrefix + "drain_duration_seconds",
+			Help:    "Time spent draining the node before rebooting.",
+			Buckets: durationBuckets,
+		}),
+		podEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricsPrefix + "pod_evictions_total",
+			Help: "Number of pods evicted, failed to evict or forcibly deleted when draining the node.",
+		}, []string{"result"}),
+		okToRebootWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    metricsPrefix + "ok_to_reboot_wait_seconds",
+			Help:    "Time spent waiting for the update-operator to allow the reboot.",
+			Buckets: durationBuckets,
+		}),
+	}
+
+	m.registry.MustRegister(m.operationTransitions, m.downloadProgress, m.drainDuration, m.podEvictions,
+		m.okToRebootWait)
+
+	return m
+}
+
+// MetricsHandler returns HTTP handler serving agent metrics in the Prometheus text format.
+func (k *Klocksmith) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(k.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// observeStatus records the given update_engine status, received after the given operation.
+func (m *agentMetrics) observeStatus(oldOperation string, status updateengine.Status) {
+	if status.CurrentOperation == updateengine.UpdateStatusDownloading {
+		m.downloadProgress.Set(status.Progress)
+	}
+
+	// Operation is only known after the first status is received.
+	if oldOperation != "" && status.CurrentOperation != oldOperation {
+		m.operationTransitions.WithLabelValues(oldOperation, status.CurrentOperation).Inc()
+	}
+}
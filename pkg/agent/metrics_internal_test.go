@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/updateengine"
+)
+
+// gatherMetrics returns metrics served by the given handler in the Prometheus text format.
+func gatherMetrics(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d serving metrics, got %d", http.StatusOK, recorder.Code)
+	}
+
+	return recorder.Body.String()
+}
+
+func Test_agentMetrics_observeStatus(t *testing.T) {
+	t.Parallel()
+
+	m := newAgentMetrics()
+
+	oldOperation := ""
+
+	for _, status := range []updateengine.Status{
+		{CurrentOperation: updateengine.UpdateStatusIdle},
+		{CurrentOperation: updateengine.UpdateStatusDownloading, Progress: 0.25},
+		{CurrentOperation: updateengine.UpdateStatusDownloading, Progress: 0.5},
+		{CurrentOperation: updateengine.UpdateStatusVerifying, Progress: 1},
+	} {
+		m.observeStatus(oldOperation, status)
+
+		oldOperation = status.CurrentOperation
+	}
+
+	output := gatherMetrics(t, (&Klocksmith{metrics: m}).MetricsHandler())
+
+	for _, expected := range []string{
+		`flatcar_linux_update_agent_update_engine_download_progress 0.5`,
+		`flatcar_linux_update_agent_update_engine_operation_transitions_total{from="UPDATE_STATUS_IDLE",` +
+			`to="UPDATE_STATUS_DOWNLOADING"} 1`,
+		`flatcar_linux_update_agent_update_engine_operation_transitions_total{from="UPDATE_STATUS_DOWNLOADING",` +
+			`to="UPDATE_STATUS_VERIFYING"} 1`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Fatalf("Expected metric %q, got:\n%s", expected, output)
+		}
+	}
+
+	if strings.Contains(output, `from=""`) {
+		t.Fatalf("Expected initial status to not be reported as transition, got:\n%s", output)
+	}
+}
@@ -0,0 +1,6 @@
+// Package v1alpha1 contains custom resource types used to configure Flatcar Linux Update Operator
+// and to report its state.
+//
+// +k8s:deepcopy-gen=package
+// +groupName=update.flatcar-linux.net
+package v1alpha1
@@ -0,0 +1,148 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// UpdatePolicyResource is the plural name of the UpdatePolicy resource.
+const UpdatePolicyResource = "updatepolicies"
+
+// UpdatePolicy is a cluster-scoped resource configuring the update-operator. Changes to the policy
+// are applied by the operator without a restart.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type UpdatePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UpdatePolicySpec   `json:"spec,omitempty"`
+	Status UpdatePolicyStatus `json:"status,omitempty"`
+}
+
+// UpdatePolicySpec is the configuration of the update-operator. Fields which are not set are taken
+// from the operator flags. Fields which are set replace the matching flags, even when set to
+// an empty value.
+type UpdatePolicySpec struct {
+	// Reboot windows in format '[<days>] <time of day> <length> <time zone>',
+	// e.g. 'Mon-Fri 22:00 4h Europe/Berlin'. Nodes may reboot when the current time
+	// falls inside any of the windows. Empty list allows reboots at any time.
+	RebootWindows []string `json:"rebootWindows,omitempty"`
+	// Reboot windows for pools of nodes selected by node labels.
+	PoolRebootWindows []PoolRebootWindows `json:"poolRebootWindows,omitempty"`
+	// Maximum number of nodes which may be rebooting or not ready at the same time.
+	// Either absolute number or a percentage of managed nodes, e.g. '10%'.
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// Node label key used to group nodes into topology domains, e.g. 'topology.kubernetes.io/zone'.
+	TopologyKey *string `json:"topologyKey,omitempty"`
+	// Maximum number of nodes which may be rebooting at the same time within a single topology domain.
+	MaxUnavailablePerTopology *intstr.IntOrString `json:"maxUnavailablePerTopology,omitempty"`
+	// Node annotations which must be set to 'true' before a node is allowed to reboot.
+	BeforeRebootAnnotations []string `json:"beforeRebootAnnotations,omitempty"`
+	// Node annotations which must be set to 'true' before a reboot of a node is completed.
+	AfterRebootAnnotations []string `json:"afterRebootAnnotations,omitempty"`
+	// Label selector for canary nodes, which are rebooted before any other node.
+	CanarySelector *string `json:"canarySelector,omitempty"`
+	// Label selector for control plane nodes, which are rebooted one at a time.
+	ControlPlaneSelector *string `json:"controlPlaneSelector,omitempty"`
+	// Reboot windows for control plane nodes, in the same format as RebootWindows.
+	ControlPlaneRebootWindows []string `json:"controlPlaneRebootWindows,omitempty"`
+}
+
+// PoolRebootWindows configures reboot windows for a pool of nodes selected by labels.
+type PoolRebootWindows struct {
+	// Label selector for nodes in the pool, e.g. 'node.kubernetes.io/pool=gpu'.
+	Selector string `json:"selector"`
+	// Reboot windows in the same format as UpdatePolicySpec.RebootWindows.
+	Windows []string `json:"windows"`
+}
+
+// UpdatePolicyStatus reports how the update-operator applied the policy.
+type UpdatePolicyStatus struct {
+	// Generation of the policy last processed by the operator.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Configuration the operator runs with, including values taken from the operator flags.
+	ObservedConfiguration *UpdatePolicySpec `json:"observedConfiguration,omitempty"`
+	// Error which occurred when applying the policy. If set, the operator keeps running
+	// with the previously applied configuration.
+	Error string `json:"error,omitempty"`
+}
+
+// UpdatePolicyList is a list of UpdatePolicy objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type UpdatePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []UpdatePolicy `json:"items"`
+}
+
+// NodeUpdateResource is the plural name of the NodeUpdate resource.
+const NodeUpdateResource = "nodeupdates"
+
+// NodeUpdatePhase is a phase of the update of a single node.
+type NodeUpdatePhase string
+
+const (
+	// NodeUpdatePhaseIdle means the node does not need a reboot.
+	NodeUpdatePhaseIdle NodeUpdatePhase = "Idle"
+	// NodeUpdatePhaseRebootNeeded means the node needs a reboot and waits until the operator
+	// allows it.
+	NodeUpdatePhaseRebootNeeded NodeUpdatePhase = "RebootNeeded"
+	// NodeUpdatePhasePaused means the node needs a reboot, but reboots of the node are paused.
+	NodeUpdatePhasePaused NodeUpdatePhase = "Paused"
+	// NodeUpdatePhaseBeforeReboot means the node waits for before-reboot checks.
+	NodeUpdatePhaseBeforeReboot NodeUpdatePhase = "BeforeReboot"
+	// NodeUpdatePhaseRebooting means the node has been allowed to reboot and it is draining
+	// or rebooting.
+	NodeUpdatePhaseRebooting NodeUpdatePhase = "Rebooting"
+	// NodeUpdatePhaseAfterReboot means the node has rebooted and it waits for after-reboot checks.
+	NodeUpdatePhaseAfterReboot NodeUpdatePhase = "AfterReboot"
+	// NodeUpdatePhaseFailed means the reboot of the node has failed, which halts all further
+	// reboots until an administrator clears the failure.
+	NodeUpdatePhaseFailed NodeUpdatePhase = "Failed"
+)
+
+// NodeUpdate reports the state of the update of a single node. It is maintained by the update-operator
+// for every node managed by the update-agent and it has the same name as the node.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type NodeUpdate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status NodeUpdateStatus `json:"status,omitempty"`
+}
+
+// NodeUpdateStatus is the state of the update of a node.
+type NodeUpdateStatus struct {
+	// Current phase of the update.
+	Phase NodeUpdatePhase `json:"phase,omitempty"`
+	// Time of the last phase transition.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Time when the node has last entered each phase.
+	Transitions []NodeUpdateTransition `json:"transitions,omitempty"`
+	// Version of Flatcar Container Linux the node runs.
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// Version of Flatcar Container Linux downloaded by update_engine, which the node runs after reboot.
+	TargetVersion string `json:"targetVersion,omitempty"`
+	// Status reported by update_engine, e.g. 'UPDATE_STATUS_UPDATED_NEED_REBOOT'.
+	UpdateEngineStatus string `json:"updateEngineStatus,omitempty"`
+	// Last error which occurred during the update of the node.
+	LastError string `json:"lastError,omitempty"`
+	// Time when the last error occurred.
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+}
+
+// NodeUpdateTransition is a time when the node has entered the phase.
+type NodeUpdateTransition struct {
+	Phase NodeUpdatePhase `json:"phase"`
+	Time  metav1.Time     `json:"time"`
+}
+
+// NodeUpdateList is a list of NodeUpdate objects.
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type NodeUpdateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NodeUpdate `json:"items"`
+}
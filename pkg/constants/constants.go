@@ -9,6 +9,10 @@ const (
 	// False is annotation value used by update-agent and update-operator.
 	False = "false"
 
+	// Failed is annotation value, which may be set by before and after reboot checks to
+	// indicate that the check has failed, in addition to False.
+	Failed = "failed"
+
 	// Prefix used by all label and annotation keys.
 	Prefix = "flatcar-linux-update.v1.flatcar-linux.net/"
 
@@ -18,19 +22,43 @@ const (
 	// LabelRebootNeeded is an label name set to "true" by the update-agent when a reboot is requested.
 	LabelRebootNeeded = Prefix + "reboot-needed"
 
+	// AnnotationRebootNeededTime is a key set by the update-agent to the time in RFC 3339 format,
+	// when it has requested a reboot.
+	AnnotationRebootNeededTime = Prefix + "reboot-needed-time"
+
 	// AnnotationRebootInProgress is a key set to "true" by the update-agent when node-drain and reboot is
 	// initiated.
 	AnnotationRebootInProgress = Prefix + "reboot-in-progress"
 
+	// AnnotationRebootInProgressTime is a key set by the update-agent to the time in RFC 3339 format,
+	// when it has set AnnotationRebootInProgress to "true" and started draining the node.
+	AnnotationRebootInProgressTime = Prefix + "reboot-in-progress-time"
+
 	// AnnotationOkToReboot is a key set to "true" by the update-operator when an agent may proceed
 	// with a node-drain and reboot.
 	AnnotationOkToReboot = Prefix + "reboot-ok"
 
+	// AnnotationOkToRebootTime is a key set by the update-operator to the time in RFC 3339 format,
+	// when it has set AnnotationOkToReboot to "true".
+	AnnotationOkToRebootTime = Prefix + "reboot-ok-time"
+
+	// AnnotationRebootFailed is a key set to "true" by the update-operator when the node did not
+	// come back ready from the reboot within the configured deadline. While any node has this
+	// annotation set to "true", the update-operator does not start any new reboots. It may be
+	// set to "false" by the administrator to resume reboots.
+	AnnotationRebootFailed = Prefix + "reboot-failed"
+
 	// AnnotationRebootPaused is a key that may be set by the administrator to "true" to prevent
 	// update-operator from considering a node for rebooting.  Never set by
 	// the update-agent or update-operator.
 	AnnotationRebootPaused = Prefix + "reboot-paused"
 
+	// AnnotationRebootPriority is a key that may be set by the administrator to an integer value
+	// to reboot nodes with higher values first, when update-operator is configured to
+	// order nodes by priority. Nodes without this annotation have priority 0. Never set by
+	// the update-agent or update-operator.
+	AnnotationRebootPriority = Prefix + "reboot-priority"
+
 	// AnnotationStatus is a key set by the update-agent to the current operator status of update_agent.
 	//
 	// Possible values are:
@@ -61,6 +89,70 @@ const (
 	// it was responsible for making node unschedulable.
 	AnnotationAgentMadeUnschedulable = Prefix + "agent-made-unschedulable"
 
+	// AnnotationRebootCompletedTime is a key set by the update-operator to the time in RFC 3339 format,
+	// when the node has successfully completed the reboot, including after-reboot checks.
+	AnnotationRebootCompletedTime = Prefix + "reboot-completed-time"
+
+	// AnnotationDrainExclude is a key that may be set on a pod to "true" to prevent the update-agent
+	// from evicting it when draining the node.
+	AnnotationDrainExclude = Prefix + "drain-exclude"
+
+	// AnnotationDrainAllowLocalStorage is a key that may be set on a pod to "true" to allow the update-agent
+	// to delete it when draining the node, even though it uses local storage and the update-agent is
+	// configured to refuse draining such pods.
+	AnnotationDrainAllowLocalStorage = Prefix + "drain-allow-local-storage"
+
+	// AnnotationDrainRefused is a key set by the update-agent to the reason why it refused to drain
+	// the node. It is removed when the update-operator allows the node to reboot again or when the node
+	// is drained successfully.
+	AnnotationDrainRefused = Prefix + "drain-refused"
+
+	// AnnotationBeforeRebootJobSucceeded is a key set to "true" by the update-operator when the configured
+	// before-reboot Job has succeeded on the node and to "failed" when it has failed.
+	AnnotationBeforeRebootJobSucceeded = Prefix + "before-reboot-job-succeeded"
+
+	// AnnotationAfterRebootJobSucceeded is a key set to "true" by the update-operator when the configured
+	// after-reboot Job has succeeded on the node and to "failed" when it has failed.
+	AnnotationAfterRebootJobSucceeded = Prefix + "after-reboot-job-succeeded"
+
+	// AnnotationBeforeRebootWebhook is a key set by the update-operator to "true" when the configured
+	// before-reboot webhook allowed the node to reboot, or to "false" when it denied it.
+	AnnotationBeforeRebootWebhook = Prefix + "before-reboot-webhook"
+
+	// AnnotationAfterRebootWebhook is a key set by the update-operator to "true" when the configured
+	// after-reboot webhook allowed the node to complete the reboot, or to "false" when it denied it.
+	AnnotationAfterRebootWebhook = Prefix + "after-reboot-webhook"
+
+	// LabelRebootJob is a key set by the update-operator on before-reboot and after-reboot Jobs
+	// to the phase of the Job, either "before-reboot" or "after-reboot".
+	LabelRebootJob = Prefix + "reboot-job"
+
+	// LabelRebootJobNodeHash is a key set by the update-operator on before-reboot and after-reboot Jobs
+	// to the hash of the name of the node the Job runs on, as node names may be too long for label values.
+	LabelRebootJobNodeHash = Prefix + "reboot-job-node-hash"
+
+	// AnnotationRebootJobNode is a key set by the update-operator on before-reboot and after-reboot Jobs
+	// to the name of the node the Job runs on.
+	AnnotationRebootJobNode = Prefix + "reboot-job-node"
+
+	// AnnotationBeforeRebootTime is a key set by the update-operator to the time in RFC 3339 format,
+	// when the node has entered the before-reboot phase.
+	AnnotationBeforeRebootTime = Prefix + "before-reboot-time"
+
+	// AnnotationAfterRebootTime is a key set by the update-operator to the time in RFC 3339 format,
+	// when the node has entered the after-reboot phase.
+	AnnotationAfterRebootTime = Prefix + "after-reboot-time"
+
+	// AnnotationRebootAbortedTime is a key set by the update-operator to the time in RFC 3339 format,
+	// when the reboot of the node has been aborted due to failed or timed out before-reboot checks
+	// or by the update-agent. Nodes with aborted reboots are considered for rebooting after all other nodes.
+	AnnotationRebootAbortedTime = Prefix + "reboot-aborted-time"
+
+	// AnnotationRebootAborted is a key set to "true" by the update-agent when it gives up the reboot
+	// it has been allowed to, e.g. because draining the node timed out or has been refused. The
+	// update-operator then sets AnnotationOkToReboot to "false" and removes this annotation.
+	AnnotationRebootAborted = Prefix + "reboot-aborted"
+
 	// LabelBeforeReboot is a key set to true when the operator is waiting for configured annotation
 	// before and after the reboot respectively.
 	LabelBeforeReboot = Prefix + "before-reboot"
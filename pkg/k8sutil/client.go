@@ -3,6 +3,7 @@ package k8sutil
 import (
 	"fmt"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -19,6 +20,17 @@ func GetClient(path string) (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(conf)
 }
 
+// GetDynamicClient returns a dynamic Kubernetes client from the kubeconfig path
+// or from the in-cluster service account environment.
+func GetDynamicClient(path string) (dynamic.Interface, error) {
+	conf, err := getClientConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes client config: %w", err)
+	}
+
+	return dynamic.NewForConfig(conf)
+}
+
 // getClientConfig returns a Kubernetes client Config.
 func getClientConfig(path string) (*rest.Config, error) {
 	if path != "" {
@@ -3,14 +3,76 @@ package k8sutil
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
 )
 
+// DrainPolicy configures which pods may not be deleted during a drain, similar to
+// the options of 'kubectl drain'.
+type DrainPolicy struct {
+	// RefuseLocalStorage refuses the drain if any pod uses emptyDir volumes, unless the pod
+	// has constants.AnnotationDrainAllowLocalStorage annotation set to "true".
+	RefuseLocalStorage bool
+	// RefuseUnmanagedPods refuses the drain if any pod is not managed by a controller, so it
+	// would not be recreated after the deletion.
+	RefuseUnmanagedPods bool
+}
+
+// CheckDrainPolicy returns an error describing pods which may not be deleted according
+// to the given drain policy. If all pods may be deleted, nil is returned.
+func CheckDrainPolicy(pods []corev1.Pod, policy DrainPolicy) error {
+	localStorage := []string{}
+	unmanaged := []string{}
+
+	for _, pod := range pods {
+		pod := pod
+
+		if policy.RefuseLocalStorage && usesLocalStorage(pod) &&
+			pod.Annotations[constants.AnnotationDrainAllowLocalStorage] != constants.True {
+			localStorage = append(localStorage, pod.Namespace+"/"+pod.Name)
+		}
+
+		if policy.RefuseUnmanagedPods && metav1.GetControllerOf(&pod) == nil {
+			unmanaged = append(unmanaged, pod.Namespace+"/"+pod.Name)
+		}
+	}
+
+	reasons := []string{}
+
+	if len(localStorage) > 0 {
+		reasons = append(reasons, fmt.Sprintf("pods with local storage: %s", strings.Join(localStorage, ", ")))
+	}
+
+	if len(unmanaged) > 0 {
+		reasons = append(reasons, fmt.Sprintf("pods not managed by a controller: %s", strings.Join(unmanaged, ", ")))
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("refusing to delete %s", strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// usesLocalStorage returns true if given pod uses emptyDir volumes, whose data would be lost
+// when the pod is deleted.
+func usesLocalStorage(pod corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetPodsForDeletion finds pods on the given node that are candidates for
 // deletion during a drain before a reboot.
 // This code mimics pod filtering behavior in
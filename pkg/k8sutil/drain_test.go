@@ -0,0 +1,81 @@
+package k8sutil_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+//nolint:funlen // Just many test cases.
+func TestCheckDrainPolicy(t *testing.T) {
+	t.Parallel()
+
+	controller := true
+
+	managed := metav1.ObjectMeta{
+		Name:            "managed",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs", Controller: &controller}},
+	}
+
+	emptyDir := corev1.PodSpec{
+		Volumes: []corev1.Volume{{
+			Name:         "scratch",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}},
+	}
+
+	allowedLocalStorage := *managed.DeepCopy()
+	allowedLocalStorage.Annotations = map[string]string{constants.AnnotationDrainAllowLocalStorage: constants.True}
+
+	cases := map[string]struct {
+		pod     corev1.Pod
+		policy  k8sutil.DrainPolicy
+		refused bool
+	}{
+		"local_storage_allowed_by_default": {
+			pod: corev1.Pod{ObjectMeta: managed, Spec: emptyDir},
+		},
+		"local_storage_refused": {
+			pod:     corev1.Pod{ObjectMeta: managed, Spec: emptyDir},
+			policy:  k8sutil.DrainPolicy{RefuseLocalStorage: true},
+			refused: true,
+		},
+		"local_storage_allowed_by_annotation": {
+			pod:    corev1.Pod{ObjectMeta: allowedLocalStorage, Spec: emptyDir},
+			policy: k8sutil.DrainPolicy{RefuseLocalStorage: true},
+		},
+		"unmanaged_pod_allowed_by_default": {
+			pod: corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare"}},
+		},
+		"unmanaged_pod_refused": {
+			pod:     corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare"}},
+			policy:  k8sutil.DrainPolicy{RefuseUnmanagedPods: true},
+			refused: true,
+		},
+		"managed_pod_allowed": {
+			pod:    corev1.Pod{ObjectMeta: managed},
+			policy: k8sutil.DrainPolicy{RefuseUnmanagedPods: true, RefuseLocalStorage: true},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := k8sutil.CheckDrainPolicy([]corev1.Pod{c.pod}, c.policy)
+			if c.refused && err == nil {
+				t.Fatalf("Expected drain to be refused")
+			}
+
+			if !c.refused && err != nil {
+				t.Fatalf("Expected drain to be allowed, got: %v", err)
+			}
+		})
+	}
+}
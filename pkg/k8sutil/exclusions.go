@@ -0,0 +1,76 @@
+package k8sutil
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+// DefaultDrainExcludedNamespaces are namespaces whose pods are not evicted by default.
+var DefaultDrainExcludedNamespaces = []string{"kube-system"}
+
+// DrainExclusions decides which pods are never evicted when draining the node. It is shared by the
+// update-agent draining the node and the update-operator estimating the impact of the drain.
+type DrainExclusions struct {
+	namespaces  map[string]struct{}
+	podSelector labels.Selector
+}
+
+// NewDrainExclusions creates DrainExclusions excluding pods from given namespaces, pods matching given
+// label selector and pods with AnnotationDrainExclude annotation set to "true". Empty selector
+// selects no pods.
+func NewDrainExclusions(namespaces []string, podSelector string) (*DrainExclusions, error) {
+	selector, err := labels.Parse(podSelector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pod selector %q: %w", podSelector, err)
+	}
+
+	e := &DrainExclusions{
+		namespaces:  map[string]struct{}{},
+		podSelector: selector,
+	}
+
+	for _, namespace := range namespaces {
+		e.namespaces[namespace] = struct{}{}
+	}
+
+	return e, nil
+}
+
+// Evictable returns true if given pod is not excluded from the drain.
+func (e *DrainExclusions) Evictable(pod *corev1.Pod) bool {
+	if _, ok := e.namespaces[pod.Namespace]; ok {
+		return false
+	}
+
+	if !e.podSelector.Empty() && e.podSelector.Matches(labels.Set(pod.Labels)) {
+		klog.V(4).Infof("Not evicting pod %q, it matches excluded pod selector %q", pod.Name, e.podSelector)
+
+		return false
+	}
+
+	if pod.Annotations[constants.AnnotationDrainExclude] == constants.True {
+		klog.V(4).Infof("Not evicting pod %q, it has annotation %q set to %q", pod.Name,
+			constants.AnnotationDrainExclude, constants.True)
+
+		return false
+	}
+
+	return true
+}
+
+// GetPodsToEvict returns pods, which are evicted when draining the given node, i.e. pods for deletion
+// which are not excluded from the drain by given exclusions.
+func GetPodsToEvict(kc kubernetes.Interface, node string, exclusions *DrainExclusions) ([]corev1.Pod, error) {
+	pods, err := GetPodsForDeletion(kc, node)
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterPods(pods, exclusions.Evictable), nil
+}
@@ -0,0 +1,77 @@
+package k8sutil_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+func TestDrainExclusionsEvictable(t *testing.T) {
+	t.Parallel()
+
+	e, err := k8sutil.NewDrainExclusions(k8sutil.DefaultDrainExcludedNamespaces, "drain=never")
+	if err != nil {
+		t.Fatalf("Creating drain exclusions: %v", err)
+	}
+
+	pod := func(namespace string, labels, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod",
+				Namespace:   namespace,
+				Labels:      labels,
+				Annotations: annotations,
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		pod       *corev1.Pod
+		evictable bool
+	}{
+		"regular_pod": {
+			pod:       pod("default", nil, nil),
+			evictable: true,
+		},
+		"excluded_namespace": {
+			pod: pod("kube-system", nil, nil),
+		},
+		"excluded_labels": {
+			pod: pod("default", map[string]string{"drain": "never"}, nil),
+		},
+		"excluded_annotation": {
+			pod: pod("default", nil, map[string]string{constants.AnnotationDrainExclude: constants.True}),
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if evictable := e.Evictable(c.pod); evictable != c.evictable {
+				t.Fatalf("Expected evictable %v, got %v", c.evictable, evictable)
+			}
+		})
+	}
+}
+
+func TestNewDrainExclusionsWithEmptySelectorExcludesNoPods(t *testing.T) {
+	t.Parallel()
+
+	e, err := k8sutil.NewDrainExclusions(nil, "")
+	if err != nil {
+		t.Fatalf("Creating drain exclusions: %v", err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "kube-system"}}
+
+	if !e.Evictable(pod) {
+		t.Fatalf("Expected pod to be evictable without exclusions")
+	}
+}
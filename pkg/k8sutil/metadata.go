@@ -65,6 +65,16 @@ func GetNodeRetry(nc v1core.NodeInterface, node string) (*v1api.Node, error) {
 // f will be called each time since the node object will likely have changed if
 // a retry is necessary.
 func UpdateNodeRetry(nc v1core.NodeInterface, node string, f func(*v1api.Node)) error {
+	_, err := UpdateNodeRetryResult(nc, node, f)
+
+	return err
+}
+
+// UpdateNodeRetryResult updates a node object like UpdateNodeRetry and returns
+// the updated node object.
+func UpdateNodeRetryResult(nc v1core.NodeInterface, node string, f func(*v1api.Node)) (*v1api.Node, error) {
+	var updated *v1api.Node
+
 	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		n, getErr := nc.Get(context.TODO(), node, v1meta.GetOptions{})
 		if getErr != nil {
@@ -73,16 +83,18 @@ func UpdateNodeRetry(nc v1core.NodeInterface, node string, f func(*v1api.Node))
 
 		f(n)
 
-		_, err := nc.Update(context.TODO(), n, v1meta.UpdateOptions{})
+		var err error
+
+		updated, err = nc.Update(context.TODO(), n, v1meta.UpdateOptions{})
 
 		return err //nolint:wrapcheck
 	})
 	if err != nil {
 		// May be conflict if max retries were hit.
-		return fmt.Errorf("unable to update node %q: %w", node, err)
+		return nil, fmt.Errorf("unable to update node %q: %w", node, err)
 	}
 
-	return nil
+	return updated, nil
 }
 
 // SetNodeLabels sets all keys in m to their respective values in
@@ -197,7 +209,7 @@ func getUpdateMap() (map[string]string, error) {
 			return nil, fmt.Errorf("reading file %q: %w", updateConfOverridePath, err)
 		}
 
-		klog.Infof("Skipping missing update.conf: %w", err)
+		klog.Infof("Skipping missing update.conf: %v", err)
 	}
 
 	splitNewlineEnv(infomap, string(updateConfOverride))
@@ -51,6 +51,12 @@ func (k *Kontroller) legacyLabeler() {
 	klog.V(6).Infof("Found Flatcar Container Linux nodes to label: %+v", nodelist.Items)
 
 	for _, node := range nodesToLabel {
+		if k.dryRun {
+			klog.Infof("Dry run: would set label 'agent=true' on %q", node.Name)
+
+			continue
+		}
+
 		klog.Infof("Setting label 'agent=true' on %q", node.Name)
 
 		if err := k8sutil.SetNodeLabels(k.nc, node.Name, enableUpdateAgentLabel); err != nil {
@@ -0,0 +1,168 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// blackoutDateFormat is a format of dates accepted in blackout periods, in addition to RFC 3339 timestamps.
+const blackoutDateFormat = "2006-01-02"
+
+// blackout is a period of time during which no new reboots may be started, e.g. a change freeze.
+type blackout struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// parseBlackouts parses blackout periods from the ConfigMap data. Each key is a name of the
+// blackout period and each value has a format '<start>/<end>', where start and end are either
+// RFC 3339 timestamps or dates in format 'YYYY-MM-DD'. Dates are interpreted in UTC and the end
+// date is inclusive, e.g. '2021-11-26/2021-11-29' blocks reboots from Friday to Monday.
+func parseBlackouts(data map[string]string) ([]blackout, error) {
+	blackouts := []blackout{}
+
+	for name, value := range data {
+		bounds := strings.Split(strings.TrimSpace(value), "/")
+		if len(bounds) != 2 { //nolint:mnd // Start and end.
+			return nil, fmt.Errorf("blackout %q: expected format '<start>/<end>', got %q", name, value)
+		}
+
+		start, err := parseBlackoutTime(bounds[0], false)
+		if err != nil {
+			return nil, fmt.Errorf("blackout %q: parsing start: %w", name, err)
+		}
+
+		end, err := parseBlackoutTime(bounds[1], true)
+		if err != nil {
+			return nil, fmt.Errorf("blackout %q: parsing end: %w", name, err)
+		}
+
+		if !end.After(start) {
+			return nil, fmt.Errorf("blackout %q: end %v must be after start %v", name, end, start)
+		}
+
+		blackouts = append(blackouts, blackout{
+			name:  name,
+			start: start,
+			end:   end,
+		})
+	}
+
+	// Sort blackouts by name, so they are always reported in the same order.
+	sort.Slice(blackouts, func(i, j int) bool {
+		return blackouts[i].name < blackouts[j].name
+	})
+
+	return blackouts, nil
+}
+
+// parseBlackoutTime parses either RFC 3339 timestamp or a date. If end is true, dates are
+// interpreted as the end of the given day.
+func parseBlackoutTime(value string, end bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(blackoutDateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("value %q is neither RFC 3339 timestamp nor date in format %q",
+			value, blackoutDateFormat)
+	}
+
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+// activeBlackout returns blackout period from the given list, which contains given time.
+func activeBlackout(blackouts []blackout, t time.Time) (blackout, bool) {
+	for _, b := range blackouts {
+		if !t.Before(b.start) && t.Before(b.end) {
+			return b, true
+		}
+	}
+
+	return blackout{}, false
+}
+
+// insideBlackout reads blackout periods from the configured ConfigMap in the operator namespace
+// and returns true if given time falls inside any of them. Missing ConfigMap means there are
+// no blackout periods. If the ConfigMap contains invalid blackout periods, all reboots are blocked
+// until it is fixed.
+func (k *Kontroller) insideBlackout(t time.Time) (bool, error) {
+	if k.blackoutConfigMap == "" {
+		return false, nil
+	}
+
+	cm, err := k.kc.CoreV1().ConfigMaps(k.namespace).Get(context.TODO(), k.blackoutConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		k.blackoutChanged("")
+
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("getting blackout ConfigMap %q: %w", k.blackoutConfigMap, err)
+	}
+
+	return k.checkBlackouts(cm, t), nil
+}
+
+// checkBlackouts returns true if given time falls inside any of the blackout periods from the given
+// ConfigMap or if the ConfigMap contains invalid blackout periods. Changes of the blackout state are
+// reported using Events, so they are not repeated on every check.
+func (k *Kontroller) checkBlackouts(cm *corev1.ConfigMap, t time.Time) bool {
+	blackouts, err := parseBlackouts(cm.Data)
+	if err != nil {
+		if k.blackoutChanged("invalid: " + err.Error()) {
+			klog.Errorf("Invalid blackout periods in ConfigMap %q, not starting any reboots: %v", cm.Name, err)
+			k.er.Eventf(cm, corev1.EventTypeWarning, "InvalidBlackout",
+				"Invalid blackout periods, not starting any reboots until fixed: %v", err)
+		}
+
+		return true
+	}
+
+	b, ok := activeBlackout(blackouts, t)
+	if !ok {
+		if k.blackoutChanged("") {
+			klog.Info("Outside of blackout periods, starting reboots again")
+		}
+
+		return false
+	}
+
+	if k.blackoutChanged("active: " + b.name) {
+		klog.Infof("Inside blackout period %q (%s - %s), not starting any reboots", b.name,
+			b.start.Format(time.RFC3339), b.end.Format(time.RFC3339))
+		k.er.Eventf(cm, corev1.EventTypeNormal, "RebootsBlocked", "Inside blackout period %q (%s - %s), "+
+			"not starting any reboots", b.name, b.start.Format(time.RFC3339), b.end.Format(time.RFC3339))
+	}
+
+	return true
+}
+
+// blackoutChanged records given blackout state and returns true if it differs from the previously
+// recorded one. Empty state means no blackout period is active.
+func (k *Kontroller) blackoutChanged(state string) bool {
+	if state == k.blackoutState {
+		return false
+	}
+
+	k.blackoutState = state
+
+	return true
+}
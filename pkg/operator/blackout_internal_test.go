@@ -0,0 +1,95 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_parseBlackouts_rejects_invalid_periods(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"",
+		"2021-11-26",
+		"2021-11-26/2021-11-29/2021-12-01",
+		"2021-11-29/2021-11-26",
+		"Black Friday/2021-11-29",
+		"2021-11-26T00:00:00/2021-11-29",
+	} {
+		if _, err := parseBlackouts(map[string]string{"test": value}); err == nil {
+			t.Errorf("Expected error when parsing %q", value)
+		}
+	}
+}
+
+func Test_activeBlackout(t *testing.T) {
+	t.Parallel()
+
+	blackouts, err := parseBlackouts(map[string]string{
+		"black-friday": "2021-11-26/2021-11-29",
+		"release":      "2021-12-01T18:00:00+01:00/2021-12-02T06:00:00+01:00",
+	})
+	if err != nil {
+		t.Fatalf("Parsing blackouts: %v", err)
+	}
+
+	cases := []struct {
+		time     time.Time
+		expected string
+	}{
+		{time.Date(2021, time.November, 25, 23, 59, 0, 0, time.UTC), ""},
+		{time.Date(2021, time.November, 26, 0, 0, 0, 0, time.UTC), "black-friday"},
+		{time.Date(2021, time.November, 29, 23, 59, 0, 0, time.UTC), "black-friday"},
+		{time.Date(2021, time.November, 30, 0, 0, 0, 0, time.UTC), ""},
+		{time.Date(2021, time.December, 1, 17, 30, 0, 0, time.UTC), "release"},
+		{time.Date(2021, time.December, 2, 5, 0, 0, 0, time.UTC), ""},
+	}
+
+	for _, c := range cases {
+		b, ok := activeBlackout(blackouts, c.time)
+		if ok != (c.expected != "") || b.name != c.expected {
+			t.Errorf("Expected blackout %q at %v, got %q", c.expected, c.time, b.name)
+		}
+	}
+}
+
+func Test_checkBlackouts_reports_only_state_changes(t *testing.T) {
+	t.Parallel()
+
+	recorder := record.NewFakeRecorder(10)
+	k := &Kontroller{er: recorder}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "blackouts"},
+		Data:       map[string]string{"black-friday": "2021-11-26/2021-11-29"},
+	}
+
+	inside := time.Date(2021, time.November, 27, 0, 0, 0, 0, time.UTC)
+	outside := time.Date(2021, time.November, 30, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if !k.checkBlackouts(cm, inside) {
+			t.Fatalf("Expected time %v to be inside blackout period", inside)
+		}
+	}
+
+	if k.checkBlackouts(cm, outside) {
+		t.Fatalf("Expected time %v to be outside blackout period", outside)
+	}
+
+	cm.Data["invalid"] = "2021-11-29/2021-11-26"
+
+	for i := 0; i < 3; i++ {
+		if !k.checkBlackouts(cm, outside) {
+			t.Fatalf("Expected invalid blackout periods to block reboots")
+		}
+	}
+
+	if len(recorder.Events) != 2 {
+		t.Fatalf("Expected one RebootsBlocked and one InvalidBlackout event, got %d", len(recorder.Events))
+	}
+}
@@ -0,0 +1,230 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+// canaries configures canary rollout, where a subset of nodes is rebooted first and
+// the rest of the nodes is only rebooted once the canary nodes run the new version
+// successfully for a given soak period.
+type canaries struct {
+	// selector selects canary nodes using node labels.
+	selector labels.Selector
+	// count selects given number of managed nodes as canaries, ordered by name.
+	count int
+	// soakPeriod is a minimal time the canary node must run the new version
+	// before other nodes are allowed to reboot into it.
+	soakPeriod time.Duration
+}
+
+// newCanaries creates canary rollout configuration. If neither the selector nor the count is set,
+// nil is returned, which means canary rollout is disabled.
+func newCanaries(selector string, count int, soakPeriod time.Duration) (*canaries, error) {
+	if selector != "" && count > 0 {
+		return nil, fmt.Errorf("canary nodes must be selected either by label selector or by count, not both")
+	}
+
+	if count < 0 {
+		return nil, fmt.Errorf("canary count must not be negative, got %d", count)
+	}
+
+	if soakPeriod < 0 {
+		return nil, fmt.Errorf("canary soak period must not be negative, got %v", soakPeriod)
+	}
+
+	if selector == "" && count == 0 {
+		return nil, nil
+	}
+
+	c := &canaries{
+		count:      count,
+		soakPeriod: soakPeriod,
+	}
+
+	if selector != "" {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("parsing canary selector %q: %w", selector, err)
+		}
+
+		c.selector = s
+	}
+
+	return c, nil
+}
+
+// nodes returns names of canary nodes out of the given managed nodes.
+func (c *canaries) nodes(managed []corev1.Node) map[string]struct{} {
+	canaryNodes := map[string]struct{}{}
+
+	if c.selector != nil {
+		for _, node := range managed {
+			if c.selector.Matches(labels.Set(node.Labels)) {
+				canaryNodes[node.Name] = struct{}{}
+			}
+		}
+
+		return canaryNodes
+	}
+
+	names := make([]string, 0, len(managed))
+	for _, node := range managed {
+		names = append(names, node.Name)
+	}
+
+	sort.Strings(names)
+
+	for i := 0; i < c.count && i < len(names); i++ {
+		canaryNodes[names[i]] = struct{}{}
+	}
+
+	return canaryNodes
+}
+
+// canaryFailed returns true if given node has finished rebooting, but did not become ready
+// or if it has been marked as failed by the failure detection.
+func canaryFailed(node corev1.Node) bool {
+	if rebootFailedSelector.Matches(fields.Set(node.Annotations)) {
+		return true
+	}
+
+	justRebooted := justRebootedSelector.Matches(fields.Set(node.Annotations)) ||
+		afterRebootReq.Matches(labels.Set(node.Labels))
+
+	return justRebooted && !isNodeReady(node)
+}
+
+// canarySoaked returns true if given canary node runs given version for at least the soak period
+// and is not in the process of rebooting. Canary node which has not been rebooted into the version
+// by the operator, so its reboot completion time is unknown, is considered soaked only when it has
+// no pending reboot, as it is not going to reboot into the version and would otherwise hold
+// the rollout back forever.
+func (c *canaries) canarySoaked(node corev1.Node, version string, now time.Time) bool {
+	if !isNodeReady(node) {
+		return false
+	}
+
+	if node.Annotations[constants.AnnotationOkToReboot] == constants.True ||
+		beforeRebootReq.Matches(labels.Set(node.Labels)) || afterRebootReq.Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	if completedTime, ok := rebootCompletedTime(node); ok && node.Labels[constants.LabelVersion] == version {
+		return !completedTime.Add(c.soakPeriod).After(now)
+	}
+
+	return node.Annotations[constants.AnnotationRebootNeeded] != constants.True
+}
+
+// rebootCompletedTime returns the time the operator recorded as the reboot completion time of
+// the given node, if any.
+func rebootCompletedTime(node corev1.Node) (time.Time, bool) {
+	completed, ok := node.Annotations[constants.AnnotationRebootCompletedTime]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	completedTime, err := time.Parse(time.RFC3339, completed)
+	if err != nil {
+		klog.Warningf("Failed parsing annotation %q on node %q: %v", constants.AnnotationRebootCompletedTime,
+			node.Name, err)
+
+		return time.Time{}, false
+	}
+
+	return completedTime, true
+}
+
+// filterCanaryRebootableNodes filters given rebootable nodes according to the canary rollout state.
+//
+// Canary nodes are always rebootable. Other nodes are only rebootable once all canary nodes run
+// the version they are going to reboot into for the soak period. If any of the canary nodes failed
+// to come back after reboot, no nodes are rebootable, which stops the rollout.
+func (k *Kontroller) filterCanaryRebootableNodes(managed, rebootable []corev1.Node) []corev1.Node {
+	if k.canaries == nil {
+		return rebootable
+	}
+
+	canaryNodes := k.canaries.nodes(managed)
+	if len(canaryNodes) == 0 {
+		klog.Warning("No canary nodes found, not labeling rebootable nodes until canary nodes are available")
+
+		return nil
+	}
+
+	canaries := []corev1.Node{}
+	failed := map[string]struct{}{}
+
+	for i := range managed {
+		node := &managed[i]
+
+		if _, ok := canaryNodes[node.Name]; !ok {
+			continue
+		}
+
+		if canaryFailed(*node) {
+			failed[node.Name] = struct{}{}
+
+			// Only report the failure once, not on every reconciliation.
+			if _, reported := k.failedCanaries[node.Name]; !reported {
+				klog.Warningf("Canary node %q failed to become ready after reboot, stopping the rollout", node.Name)
+				k.er.Eventf(node, corev1.EventTypeWarning, "CanaryFailed",
+					"Canary node did not become ready after reboot, stopping the rollout")
+			}
+		}
+
+		canaries = append(canaries, *node)
+	}
+
+	k.failedCanaries = failed
+
+	if len(failed) > 0 {
+		klog.V(4).Infof("%d canary nodes failed, not labeling rebootable nodes", len(failed))
+
+		return nil
+	}
+
+	now := time.Now()
+	filtered := []corev1.Node{}
+
+	for _, node := range rebootable {
+		if _, ok := canaryNodes[node.Name]; ok {
+			filtered = append(filtered, node)
+
+			continue
+		}
+
+		version := node.Annotations[constants.AnnotationNewVersion]
+
+		if !allCanariesSoaked(k.canaries, canaries, version, now) {
+			klog.V(4).Infof("Node %q waits for canary nodes to run version %q for %v", node.Name, version,
+				k.canaries.soakPeriod)
+
+			continue
+		}
+
+		filtered = append(filtered, node)
+	}
+
+	return filtered
+}
+
+// allCanariesSoaked returns true if all given canary nodes run given version for the soak period.
+func allCanariesSoaked(c *canaries, canaryNodes []corev1.Node, version string, now time.Time) bool {
+	for _, canary := range canaryNodes {
+		if !c.canarySoaked(canary, version, now) {
+			return false
+		}
+	}
+
+	return true
+}
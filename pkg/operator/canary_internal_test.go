@@ -0,0 +1,233 @@
+package operator
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_canarySoaked_requires_new_version_for_soak_period(t *testing.T) {
+	t.Parallel()
+
+	c, err := newCanaries("", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("Creating canaries: %v", err)
+	}
+
+	now := time.Now()
+
+	canary := func(version string, completed time.Time) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "canary",
+				Labels: map[string]string{constants.LabelVersion: version},
+				Annotations: map[string]string{
+					constants.AnnotationRebootCompletedTime: completed.UTC().Format(time.RFC3339),
+				},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	pending := canary("2.0.0", now.Add(-2*time.Hour))
+	pending.Annotations[constants.AnnotationRebootNeeded] = constants.True
+
+	if c.canarySoaked(pending, "3.0.0", now) {
+		t.Errorf("Canary waiting to reboot into new version should not be considered soaked")
+	}
+
+	if c.canarySoaked(canary("3.0.0", now.Add(-time.Minute)), "3.0.0", now) {
+		t.Errorf("Canary which rebooted recently should not be considered soaked")
+	}
+
+	if !c.canarySoaked(canary("3.0.0", now.Add(-2*time.Hour)), "3.0.0", now) {
+		t.Errorf("Canary running new version longer than soak period should be considered soaked")
+	}
+
+	untracked := canary("3.0.0", now)
+	delete(untracked.Annotations, constants.AnnotationRebootCompletedTime)
+
+	if !c.canarySoaked(untracked, "3.0.0", now) {
+		t.Errorf("Canary without known reboot completion time and without pending reboot should be considered soaked")
+	}
+
+	untracked.Annotations[constants.AnnotationRebootNeeded] = constants.True
+
+	if c.canarySoaked(untracked, "3.0.0", now) {
+		t.Errorf("Canary without known reboot completion time with pending reboot should not be considered soaked")
+	}
+
+	if !c.canarySoaked(canary("2.0.0", now), "3.0.0", now) {
+		t.Errorf("Canary running different version without pending reboot should be considered soaked")
+	}
+}
+
+func Test_newCanaries_rejects_both_selector_and_count(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newCanaries("canary=true", 1, time.Hour); err == nil {
+		t.Fatalf("Expected error when both canary selector and count are set")
+	}
+}
+
+func canaryTestNode(name, version string, annotations map[string]string) corev1.Node {
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{constants.LabelVersion: version},
+			Annotations: map[string]string{constants.AnnotationNewVersion: "3.0.0"},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	for k, v := range annotations {
+		node.Annotations[k] = v
+	}
+
+	return node
+}
+
+//nolint:funlen // Just many test cases.
+func Test_filterCanaryRebootableNodes(t *testing.T) {
+	t.Parallel()
+
+	soaked := map[string]string{
+		constants.AnnotationRebootCompletedTime: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+	}
+	soaking := map[string]string{
+		constants.AnnotationRebootCompletedTime: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	}
+	failed := map[string]string{
+		constants.AnnotationRebootFailed: constants.True,
+	}
+	pending := map[string]string{
+		constants.AnnotationRebootNeeded: constants.True,
+	}
+
+	cases := map[string]struct {
+		canaries   []corev1.Node
+		rebootable []corev1.Node
+		expected   []string
+	}{
+		"canary_nodes_are_always_rebootable": {
+			canaries:   []corev1.Node{canaryTestNode("a", "2.0.0", pending)},
+			rebootable: []corev1.Node{canaryTestNode("a", "2.0.0", pending), canaryTestNode("z", "2.0.0", nil)},
+			expected:   []string{"a"},
+		},
+		"other_nodes_are_rebootable_when_all_canaries_soaked": {
+			canaries: []corev1.Node{
+				canaryTestNode("a", "3.0.0", soaked),
+				canaryTestNode("b", "3.0.0", soaked),
+			},
+			rebootable: []corev1.Node{canaryTestNode("z", "2.0.0", nil)},
+			expected:   []string{"z"},
+		},
+		"other_nodes_wait_for_all_canaries_to_soak": {
+			canaries: []corev1.Node{
+				canaryTestNode("a", "3.0.0", soaked),
+				canaryTestNode("b", "3.0.0", soaking),
+			},
+			rebootable: []corev1.Node{canaryTestNode("z", "2.0.0", nil)},
+		},
+		"other_nodes_wait_for_all_canaries_to_run_new_version": {
+			canaries: []corev1.Node{
+				canaryTestNode("a", "3.0.0", soaked),
+				canaryTestNode("b", "2.0.0", pending),
+			},
+			rebootable: []corev1.Node{canaryTestNode("b", "2.0.0", pending), canaryTestNode("z", "2.0.0", nil)},
+			expected:   []string{"b"},
+		},
+		"other_nodes_wait_for_canaries_with_unknown_reboot_completion_time_and_pending_reboot": {
+			canaries:   []corev1.Node{canaryTestNode("a", "3.0.0", pending)},
+			rebootable: []corev1.Node{canaryTestNode("z", "2.0.0", nil)},
+		},
+		"other_nodes_are_rebootable_when_canaries_have_no_pending_reboot": {
+			canaries: []corev1.Node{
+				canaryTestNode("a", "3.0.0", nil),
+				canaryTestNode("b", "2.0.0", nil),
+			},
+			rebootable: []corev1.Node{canaryTestNode("z", "2.0.0", nil)},
+			expected:   []string{"z"},
+		},
+		"failed_canary_stops_the_rollout": {
+			canaries: []corev1.Node{
+				canaryTestNode("a", "3.0.0", soaked),
+				canaryTestNode("b", "3.0.0", failed),
+				canaryTestNode("c", "2.0.0", nil),
+			},
+			rebootable: []corev1.Node{canaryTestNode("c", "2.0.0", nil), canaryTestNode("z", "2.0.0", nil)},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			canaries, err := newCanaries("canary=true", 0, time.Hour)
+			if err != nil {
+				t.Fatalf("Creating canaries: %v", err)
+			}
+
+			managed := []corev1.Node{canaryTestNode("z", "2.0.0", nil)}
+
+			for _, node := range c.canaries {
+				node.Labels["canary"] = "true"
+				managed = append(managed, node)
+			}
+
+			k := &Kontroller{canaries: canaries, er: record.NewFakeRecorder(10)}
+
+			names := []string{}
+
+			for _, node := range k.filterCanaryRebootableNodes(managed, c.rebootable) {
+				names = append(names, node.Name)
+			}
+
+			sort.Strings(names)
+
+			if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+				t.Fatalf("Expected rebootable nodes %v, got %v", c.expected, names)
+			}
+		})
+	}
+}
+
+func Test_filterCanaryRebootableNodes_reports_canary_failure_once(t *testing.T) {
+	t.Parallel()
+
+	canaries, err := newCanaries("", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("Creating canaries: %v", err)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	k := &Kontroller{canaries: canaries, er: recorder}
+
+	managed := []corev1.Node{
+		canaryTestNode("a", "3.0.0", map[string]string{constants.AnnotationRebootFailed: constants.True}),
+		canaryTestNode("z", "2.0.0", nil),
+	}
+
+	for i := 0; i < 3; i++ {
+		if rebootable := k.filterCanaryRebootableNodes(managed, managed[1:]); len(rebootable) != 0 {
+			t.Fatalf("Expected no rebootable nodes with failed canary, got %d", len(rebootable))
+		}
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected exactly one event reporting canary failure, got %d", len(recorder.Events))
+	}
+}
@@ -0,0 +1,111 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+// defaultMaxUnavailable is a default number of nodes, which may be unavailable at the same time.
+const defaultMaxUnavailable = 1
+
+// parseMaxUnavailable parses given value as either absolute number of nodes (e.g. '3') or as a percentage
+// of managed nodes (e.g. '10%'). Empty value selects the default.
+func parseMaxUnavailable(value string) (intstr.IntOrString, error) {
+	if value == "" {
+		return intstr.FromInt(defaultMaxUnavailable), nil
+	}
+
+	maxUnavailable := intstr.Parse(value)
+
+	if maxUnavailable.Type == intstr.String && !strings.HasSuffix(maxUnavailable.StrVal, "%") {
+		return intstr.IntOrString{}, fmt.Errorf("value %q must be either a number or a percentage", value)
+	}
+
+	// Validate the value using 100 nodes, so percentage values are returned as is.
+	v, err := intstr.GetValueFromIntOrPercent(&maxUnavailable, 100, false)
+	if err != nil {
+		return intstr.IntOrString{}, fmt.Errorf("parsing value %q: %w", value, err)
+	}
+
+	if v < 1 {
+		return intstr.IntOrString{}, fmt.Errorf("value %q must be greater than zero", value)
+	}
+
+	if maxUnavailable.Type == intstr.String && v > 100 {
+		return intstr.IntOrString{}, fmt.Errorf("percentage %q must not be greater than 100%%", value)
+	}
+
+	return maxUnavailable, nil
+}
+
+// maxUnavailableNodes returns the number of nodes which may be unavailable at the same time out of
+// the given total number of managed nodes. Percentages are rounded down, but the result is never
+// lower than 1, so reboots can progress on small clusters as well.
+func maxUnavailableNodes(maxUnavailable intstr.IntOrString, total int) int {
+	v, err := intstr.GetValueFromIntOrPercent(&maxUnavailable, total, false)
+	if err != nil || v < 1 {
+		return 1
+	}
+
+	return v
+}
+
+// managedNodes returns nodes which are managed by the update-agent. The agent sets
+// constants.AnnotationRebootNeeded annotation when it starts, so every node having
+// this annotation has been seen by the agent at least once.
+func managedNodes(nodes []corev1.Node) []corev1.Node {
+	var managed []corev1.Node
+
+	for _, node := range nodes {
+		if _, ok := node.Annotations[constants.AnnotationRebootNeeded]; ok {
+			managed = append(managed, node)
+		}
+	}
+
+	return managed
+}
+
+// isNodeReady returns true if node reports the Ready condition with status True.
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// unavailableNodes returns nodes which are in the process of rebooting together with
+// managed nodes which are not ready for other reasons. Each node is returned only once.
+func unavailableNodes(rebootingNodes, managed []corev1.Node) []corev1.Node {
+	seen := map[string]struct{}{}
+	unavailable := []corev1.Node{}
+
+	for _, node := range rebootingNodes {
+		if _, ok := seen[node.Name]; ok {
+			continue
+		}
+
+		seen[node.Name] = struct{}{}
+
+		unavailable = append(unavailable, node)
+	}
+
+	for _, node := range managed {
+		if _, ok := seen[node.Name]; ok || isNodeReady(node) {
+			continue
+		}
+
+		seen[node.Name] = struct{}{}
+
+		unavailable = append(unavailable, node)
+	}
+
+	return unavailable
+}
@@ -0,0 +1,83 @@
+package operator
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_parseMaxUnavailable_rejects_invalid_values(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"0", "-1", "0%", "101%", "foo", "10 nodes"} {
+		if _, err := parseMaxUnavailable(value); err == nil {
+			t.Errorf("Expected error when parsing %q", value)
+		}
+	}
+}
+
+func Test_maxUnavailableNodes(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		value    string
+		total    int
+		expected int
+	}{
+		{"", 10, 1},
+		{"3", 10, 3},
+		{"3", 2, 3},
+		{"10%", 300, 30},
+		{"10%", 15, 1},
+		{"10%", 5, 1},
+		{"100%", 7, 7},
+	}
+
+	for _, c := range cases {
+		maxUnavailable, err := parseMaxUnavailable(c.value)
+		if err != nil {
+			t.Fatalf("Parsing %q: %v", c.value, err)
+		}
+
+		if got := maxUnavailableNodes(maxUnavailable, c.total); got != c.expected {
+			t.Errorf("Expected %d nodes for %q out of %d, got %d", c.expected, c.value, c.total, got)
+		}
+	}
+}
+
+func Test_unavailableNodes_counts_not_ready_managed_nodes_once(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, ready corev1.ConditionStatus) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.False},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	rebooting := []corev1.Node{node("rebooting", corev1.ConditionFalse)}
+	managed := managedNodes([]corev1.Node{
+		node("rebooting", corev1.ConditionFalse),
+		node("ready", corev1.ConditionTrue),
+		node("not-ready", corev1.ConditionUnknown),
+		{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged"}},
+	})
+
+	unavailable := unavailableNodes(rebooting, managed)
+
+	if len(unavailable) != 2 {
+		t.Fatalf("Expected 2 unavailable nodes, got %d: %v", len(unavailable), unavailable)
+	}
+
+	if unavailable[0].Name != "rebooting" || unavailable[1].Name != "not-ready" {
+		t.Fatalf("Unexpected unavailable nodes: %s, %s", unavailable[0].Name, unavailable[1].Name)
+	}
+}
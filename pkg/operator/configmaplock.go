@@ -0,0 +1,129 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// ConfigMapsLeasesResourceLock is a leader election lock type holding both ConfigMap and Lease locks.
+	ConfigMapsLeasesResourceLock = "configmapsleases"
+	// ConfigMapsResourceLock is a leader election lock type holding only the ConfigMap lock, used by
+	// older versions of the operator.
+	ConfigMapsResourceLock = "configmaps"
+)
+
+// configMapLock is a leader election lock storing the leader election record in the annotation of
+// a ConfigMap, like older versions of the operator do. The client-go library no longer provides it,
+// but it is still needed to migrate from those versions to the Lease lock.
+type configMapLock struct {
+	meta   metav1.ObjectMeta
+	client corev1client.ConfigMapsGetter
+	config resourcelock.ResourceLockConfig
+	cm     *corev1.ConfigMap
+}
+
+// Get returns the leader election record from the ConfigMap annotation.
+func (l *configMapLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	cm, err := l.client.ConfigMaps(l.meta.Namespace).Get(ctx, l.meta.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting ConfigMap: %w", err)
+	}
+
+	l.cm = cm
+
+	record := &resourcelock.LeaderElectionRecord{}
+
+	recordBytes, ok := cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
+	if !ok {
+		return record, nil, nil
+	}
+
+	if err := json.Unmarshal([]byte(recordBytes), record); err != nil {
+		return nil, nil, fmt.Errorf("decoding leader election record: %w", err)
+	}
+
+	return record, []byte(recordBytes), nil
+}
+
+// Create creates the ConfigMap with the given leader election record.
+func (l *configMapLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	recordBytes, err := json.Marshal(ler)
+	if err != nil {
+		return fmt.Errorf("encoding leader election record: %w", err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      l.meta.Name,
+			Namespace: l.meta.Namespace,
+			Annotations: map[string]string{
+				resourcelock.LeaderElectionRecordAnnotationKey: string(recordBytes),
+			},
+		},
+	}
+
+	cm, err = l.client.ConfigMaps(l.meta.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating ConfigMap: %w", err)
+	}
+
+	l.cm = cm
+
+	return nil
+}
+
+// Update updates the leader election record in the ConfigMap annotation.
+func (l *configMapLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	if l.cm == nil {
+		return fmt.Errorf("ConfigMap not initialized, call get or create first")
+	}
+
+	recordBytes, err := json.Marshal(ler)
+	if err != nil {
+		return fmt.Errorf("encoding leader election record: %w", err)
+	}
+
+	cm := l.cm.DeepCopy()
+
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+
+	cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey] = string(recordBytes)
+
+	cm, err = l.client.ConfigMaps(l.meta.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("updating ConfigMap: %w", err)
+	}
+
+	l.cm = cm
+
+	return nil
+}
+
+// RecordEvent records an event about the leader election on the ConfigMap.
+func (l *configMapLock) RecordEvent(s string) {
+	if l.config.EventRecorder == nil || l.cm == nil {
+		return
+	}
+
+	l.config.EventRecorder.Eventf(&corev1.ConfigMap{ObjectMeta: l.cm.ObjectMeta}, corev1.EventTypeNormal,
+		"LeaderElection", "%v %v", l.config.Identity, s)
+}
+
+// Describe returns the namespace and the name of the ConfigMap.
+func (l *configMapLock) Describe() string {
+	return fmt.Sprintf("%v/%v", l.meta.Namespace, l.meta.Name)
+}
+
+// Identity returns the identity of the lock holder.
+func (l *configMapLock) Identity() string {
+	return l.config.Identity
+}
@@ -0,0 +1,57 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func Test_configMapLock_stores_leader_election_record_in_ConfigMap_annotation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	kc := fake.NewSimpleClientset()
+
+	lock := &configMapLock{
+		meta:   metav1.ObjectMeta{Namespace: "default", Name: leaderElectionResourceName},
+		client: kc.CoreV1(),
+		config: resourcelock.ResourceLockConfig{Identity: "foo"},
+	}
+
+	if _, _, err := lock.Get(ctx); !apierrors.IsNotFound(err) {
+		t.Fatalf("Expected not found error getting missing lock, got: %v", err)
+	}
+
+	if err := lock.Create(ctx, resourcelock.LeaderElectionRecord{HolderIdentity: "foo"}); err != nil {
+		t.Fatalf("Creating lock: %v", err)
+	}
+
+	updated := resourcelock.LeaderElectionRecord{HolderIdentity: "foo", LeaderTransitions: 1}
+
+	if err := lock.Update(ctx, updated); err != nil {
+		t.Fatalf("Updating lock: %v", err)
+	}
+
+	// ConfigMap must be readable by older operator versions.
+	cm, err := kc.CoreV1().ConfigMaps("default").Get(ctx, leaderElectionResourceName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting ConfigMap: %v", err)
+	}
+
+	if _, ok := cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]; !ok {
+		t.Fatalf("Expected leader election record annotation, got %v", cm.Annotations)
+	}
+
+	record, _, err := lock.Get(ctx)
+	if err != nil {
+		t.Fatalf("Getting lock: %v", err)
+	}
+
+	if record.HolderIdentity != "foo" || record.LeaderTransitions != 1 {
+		t.Fatalf("Expected updated leader election record, got %+v", record)
+	}
+}
@@ -0,0 +1,82 @@
+package operator
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// DefaultControlPlaneSelector selects control plane nodes using the label set by kubeadm.
+const DefaultControlPlaneSelector = "node-role.kubernetes.io/control-plane"
+
+// parseControlPlaneSelector parses label selector for control plane nodes. Empty selector
+// selects DefaultControlPlaneSelector.
+func parseControlPlaneSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		selector = DefaultControlPlaneSelector
+	}
+
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing selector %q: %w", selector, err)
+	}
+
+	if s.Empty() {
+		return nil, fmt.Errorf("selector must not be empty")
+	}
+
+	return s, nil
+}
+
+// isControlPlane returns true if the given node is a control plane node.
+func (k *Kontroller) isControlPlane(node corev1.Node) bool {
+	return k.controlPlaneSelector.Matches(labels.Set(node.Labels))
+}
+
+// splitControlPlaneNodes splits given nodes into worker and control plane nodes, keeping their order.
+func (k *Kontroller) splitControlPlaneNodes(nodes []corev1.Node) (workers, controlPlane []corev1.Node) {
+	for _, node := range nodes {
+		if k.isControlPlane(node) {
+			controlPlane = append(controlPlane, node)
+
+			continue
+		}
+
+		workers = append(workers, node)
+	}
+
+	return workers, controlPlane
+}
+
+// controlPlaneCandidates returns control plane nodes out of the given rebootable control plane
+// nodes, which may be considered for reboot. Only one of them may be chosen and only when no other
+// node is rebooting.
+//
+// Control plane nodes reboot only after worker nodes, so they are only considered when no worker
+// node has been chosen for reboot in this reconciliation. Worker nodes which want to reboot, but are
+// not rebootable right now, e.g. because they are outside of their reboot windows, paused, gated by
+// canary rollout or blocked by PodDisruptionBudgets, do not hold control plane nodes back.
+func (k *Kontroller) controlPlaneCandidates(chosenWorkers []*corev1.Node, rebootingNodes,
+	rebootableControlPlane []corev1.Node) []corev1.Node {
+	if len(rebootableControlPlane) == 0 {
+		return nil
+	}
+
+	if len(rebootingNodes) > 0 {
+		klog.V(4).Infof("Found %d nodes rebooting; control plane nodes are rebooted when no other node is "+
+			"rebooting", len(rebootingNodes))
+
+		return nil
+	}
+
+	if len(chosenWorkers) > 0 {
+		klog.Infof("Found %d worker nodes rebootable now; control plane nodes are rebooted after them",
+			len(chosenWorkers))
+
+		return nil
+	}
+
+	return rebootableControlPlane
+}
@@ -0,0 +1,62 @@
+package operator
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_controlPlaneCandidates_waits_for_rebootable_workers(t *testing.T) {
+	t.Parallel()
+
+	selector, err := parseControlPlaneSelector("")
+	if err != nil {
+		t.Fatalf("Parsing control plane selector: %v", err)
+	}
+
+	k := &Kontroller{controlPlaneSelector: selector}
+
+	node := func(name string, controlPlane bool) corev1.Node {
+		n := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{},
+				Annotations: map[string]string{
+					constants.AnnotationRebootNeeded:     constants.True,
+					constants.AnnotationRebootInProgress: constants.False,
+					constants.AnnotationRebootPaused:     constants.False,
+				},
+			},
+		}
+
+		if controlPlane {
+			n.Labels[DefaultControlPlaneSelector] = ""
+		}
+
+		return n
+	}
+
+	nodes := []corev1.Node{node("worker", false), node("control-plane", true)}
+
+	workers, controlPlane := k.splitControlPlaneNodes(nodes)
+	if len(workers) != 1 || len(controlPlane) != 1 {
+		t.Fatalf("Expected 1 worker and 1 control plane node, got %d and %d", len(workers), len(controlPlane))
+	}
+
+	if c := k.controlPlaneCandidates([]*corev1.Node{&workers[0]}, nil, controlPlane); len(c) != 0 {
+		t.Errorf("Expected no control plane candidates while worker is chosen for reboot, got %d", len(c))
+	}
+
+	if c := k.controlPlaneCandidates(nil, workers, controlPlane); len(c) != 0 {
+		t.Errorf("Expected no control plane candidates while worker is rebooting, got %d", len(c))
+	}
+
+	// Worker wanting to reboot, which could not be chosen, e.g. because it is outside of its reboot
+	// windows, does not hold control plane nodes back.
+	if c := k.controlPlaneCandidates(nil, nil, controlPlane); len(c) != 1 || c[0].Name != "control-plane" {
+		t.Errorf("Expected control plane candidate when no worker is rebootable, got %d", len(c))
+	}
+}
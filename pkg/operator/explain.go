@@ -0,0 +1,238 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+// ExplainNode returns human readable reasons why the given node is not rebooting yet or what it waits
+// for in its current phase, based on the labels and annotations of the node and of all given nodes.
+//
+// The operator configuration is taken from the given spec, usually the observed configuration reported
+// in the UpdatePolicy status. If it is nil, reasons depending on the configuration, like reboot windows
+// or the number of unavailable nodes, are not reported. Reasons depending on other resources, like
+// blackout periods or PodDisruptionBudgets, are never reported.
+func ExplainNode(node corev1.Node, nodes []corev1.Node, config *updatev1alpha1.UpdatePolicySpec,
+	now time.Time) ([]string, error) {
+	if _, ok := node.Annotations[constants.AnnotationRebootNeeded]; !ok {
+		return []string{"Node is not managed by update-agent"}, nil
+	}
+
+	spec := updatev1alpha1.UpdatePolicySpec{}
+	if config != nil {
+		spec = *config
+	}
+
+	k := &Kontroller{}
+
+	if err := k.configure(applyUpdatePolicy(Config{}, spec)); err != nil {
+		return nil, fmt.Errorf("applying configuration: %w", err)
+	}
+
+	phases := k.phases()
+
+	switch NodePhase(node) {
+	case updatev1alpha1.NodeUpdatePhaseFailed:
+		return []string{fmt.Sprintf("Reboot of the node failed, reboots of all nodes are halted until annotation "+
+			"%q is set to %q", constants.AnnotationRebootFailed, constants.False)}, nil
+	case updatev1alpha1.NodeUpdatePhaseIdle:
+		return []string{fmt.Sprintf("Node does not need a reboot, update_engine status is %q",
+			node.Annotations[constants.AnnotationStatus])}, nil
+	case updatev1alpha1.NodeUpdatePhasePaused:
+		return []string{fmt.Sprintf("Reboots of the node are paused using annotation %q",
+			constants.AnnotationRebootPaused)}, nil
+	case updatev1alpha1.NodeUpdatePhaseBeforeReboot:
+		return explainRebootPhase(node, phases[0], config != nil, now), nil
+	case updatev1alpha1.NodeUpdatePhaseAfterReboot:
+		return explainRebootPhase(node, phases[1], config != nil, now), nil
+	case updatev1alpha1.NodeUpdatePhaseRebooting:
+		return []string{explainRebooting(node)}, nil
+	}
+
+	reasons := []string{}
+
+	for _, n := range k8sutil.FilterNodesByAnnotation(nodes, rebootFailedSelector) {
+		reasons = append(reasons, fmt.Sprintf("Reboots are halted, because reboot of node %q failed", n.Name))
+	}
+
+	if config == nil {
+		return append(reasons, "Configuration of update-operator is unknown, reboot windows and limits "+
+			"of unavailable nodes were not checked"), nil
+	}
+
+	reasons = append(reasons, k.explainRebootNeeded(node, nodes, now)...)
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "Node waits to be chosen for reboot. It may be delayed by blackout periods, "+
+			"PodDisruptionBudgets, topology limits or by other nodes rebooting first")
+	}
+
+	return reasons, nil
+}
+
+// explainRebootPhase explains what the node waits for in the given before-reboot or after-reboot phase.
+func explainRebootPhase(node corev1.Node, phase rebootPhase, configured bool, now time.Time) []string {
+	if reason := phaseFailure(node, phase, now); reason != "" {
+		return []string{fmt.Sprintf("Node failed %s checks and its reboot is going to be aborted: %s", phase.name,
+			reason)}
+	}
+
+	if !configured {
+		return []string{fmt.Sprintf("Node waits for %s checks, configured annotations are unknown", phase.name)}
+	}
+
+	missing := []string{}
+
+	for _, annotation := range phase.annotations {
+		if node.Annotations[annotation] != constants.True {
+			missing = append(missing, fmt.Sprintf("%q", annotation))
+		}
+	}
+
+	if len(missing) == 0 {
+		return []string{fmt.Sprintf("Node passed %s checks and waits for update-operator to proceed", phase.name)}
+	}
+
+	return []string{fmt.Sprintf("Node waits for %s annotations to be set to %q: %s", phase.name, constants.True,
+		strings.Join(missing, ", "))}
+}
+
+// explainRebooting explains what the node, which has been allowed to reboot, waits for.
+func explainRebooting(node corev1.Node) string {
+	if reason := node.Annotations[constants.AnnotationDrainRefused]; reason != "" {
+		return fmt.Sprintf("update-agent refused to drain the node: %s", reason)
+	}
+
+	if node.Annotations[constants.AnnotationRebootInProgress] == constants.True {
+		return "update-agent is draining and rebooting the node"
+	}
+
+	return "Node has been allowed to reboot and waits for update-agent to drain and reboot it"
+}
+
+// explainRebootNeeded returns reasons why the node, which needs a reboot, has not been chosen for
+// a reboot with the current configuration.
+func (k *Kontroller) explainRebootNeeded(node corev1.Node, nodes []corev1.Node, now time.Time) []string {
+	reasons := []string{}
+
+	if !insideRebootWindows(k.rebootWindowsFor(node), now) {
+		reasons = append(reasons, "Current time is outside of the reboot windows of the node")
+	}
+
+	// Same as when choosing nodes for reboot, nodes running before and after reboot checks are rebooting too.
+	rebootingNodes := k8sutil.FilterNodesByAnnotation(nodes, stillRebootingSelector)
+	rebootingNodes = append(rebootingNodes, k8sutil.FilterNodesByRequirement(nodes, beforeRebootReq)...)
+	rebootingNodes = append(rebootingNodes, k8sutil.FilterNodesByRequirement(nodes, afterRebootReq)...)
+
+	managed := managedNodes(nodes)
+	unavailable := unavailableNodes(rebootingNodes, managed)
+
+	if maxUnavailable := maxUnavailableNodes(k.maxUnavailable, len(managed)); len(unavailable) >= maxUnavailable {
+		names := []string{}
+
+		for _, n := range unavailable {
+			names = append(names, fmt.Sprintf("%q", n.Name))
+		}
+
+		reasons = append(reasons, fmt.Sprintf("%d of maximum %d nodes are rebooting or not ready: %s",
+			len(unavailable), maxUnavailable, strings.Join(names, ", ")))
+	}
+
+	if _, rebootingControlPlane := k.splitControlPlaneNodes(rebootingNodes); len(rebootingControlPlane) > 0 {
+		reasons = append(reasons, fmt.Sprintf("Control plane node %q is rebooting", rebootingControlPlane[0].Name))
+	}
+
+	if k.isControlPlane(node) {
+		reasons = append(reasons, k.explainControlPlane(nodes, rebootingNodes, managed, now)...)
+	}
+
+	if reason := k.explainCanary(node, managed, now); reason != "" {
+		reasons = append(reasons, reason)
+	}
+
+	if reason := node.Annotations[constants.AnnotationDrainRefused]; reason != "" {
+		reasons = append(reasons, fmt.Sprintf("update-agent refused to drain the node: %s. The node is not "+
+			"chosen for reboot again until the drain refused backoff expires", reason))
+	}
+
+	if aborted := node.Annotations[constants.AnnotationRebootAbortedTime]; aborted != "" {
+		reasons = append(reasons, fmt.Sprintf("Reboot of the node was aborted at %s, it is not chosen for "+
+			"reboot until the abort backoff expires and then it is considered after other nodes", aborted))
+	}
+
+	return reasons
+}
+
+// explainControlPlane returns reasons why the control plane node has not been chosen for a reboot.
+func (k *Kontroller) explainControlPlane(nodes, rebootingNodes, managed []corev1.Node, now time.Time) []string {
+	reasons := []string{}
+
+	if len(rebootingNodes) > 0 {
+		reasons = append(reasons, "Control plane nodes are only rebooted when no other node is rebooting")
+	}
+
+	// Same as when choosing nodes for reboot, only worker nodes rebootable right now are waited for.
+	workers, _ := k.splitControlPlaneNodes(k8sutil.FilterNodesByAnnotation(nodes, wantsRebootSelector))
+	rebootable := 0
+
+	for _, worker := range workers {
+		if insideRebootWindows(k.rebootWindowsFor(worker), now) && k.explainCanary(worker, managed, now) == "" {
+			rebootable++
+		}
+	}
+
+	if rebootable > 0 {
+		reasons = append(reasons, fmt.Sprintf("Control plane nodes are rebooted after worker nodes, %d worker "+
+			"nodes may reboot now", rebootable))
+	}
+
+	return reasons
+}
+
+// explainCanary returns the reason why the node waits for canary nodes. If it does not, empty string
+// is returned. The soak period is not part of the reported configuration, so only canary nodes not
+// running the new version yet are reported.
+func (k *Kontroller) explainCanary(node corev1.Node, managed []corev1.Node, now time.Time) string {
+	if k.canaries == nil {
+		return ""
+	}
+
+	canaryNodes := k.canaries.nodes(managed)
+	if _, ok := canaryNodes[node.Name]; ok {
+		return ""
+	}
+
+	if len(canaryNodes) == 0 {
+		return "No canary nodes found, the rollout waits until canary nodes are available"
+	}
+
+	version := node.Annotations[constants.AnnotationNewVersion]
+	// All canary nodes running the version at all is enough, as the soak period is unknown.
+	unknownSoakPeriod := &canaries{}
+	soaking := []corev1.Node{}
+
+	for _, n := range managed {
+		if _, ok := canaryNodes[n.Name]; !ok {
+			continue
+		}
+
+		if canaryFailed(n) {
+			return fmt.Sprintf("Canary node %q failed to become ready after reboot, the rollout is stopped", n.Name)
+		}
+
+		soaking = append(soaking, n)
+	}
+
+	if allCanariesSoaked(unknownSoakPeriod, soaking, version, now) {
+		return ""
+	}
+
+	return fmt.Sprintf("Node waits for canary nodes to run version %q", version)
+}
@@ -0,0 +1,170 @@
+package operator_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/operator"
+)
+
+func explainNode(name string, labels, annotations map[string]string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+//nolint:funlen // Just many test cases.
+func TestExplainNode(t *testing.T) {
+	t.Parallel()
+
+	// Tuesday.
+	now := time.Date(2021, time.March, 2, 12, 0, 0, 0, time.UTC)
+
+	wantsReboot := map[string]string{constants.AnnotationRebootNeeded: constants.True}
+	idle := map[string]string{constants.AnnotationRebootNeeded: constants.False}
+	maxUnavailable := intstr.FromInt(1)
+
+	cases := map[string]struct {
+		node     corev1.Node
+		nodes    []corev1.Node
+		config   *updatev1alpha1.UpdatePolicySpec
+		expected []string
+	}{
+		"unmanaged_node": {
+			node:     explainNode("a", nil, nil),
+			config:   &updatev1alpha1.UpdatePolicySpec{},
+			expected: []string{"not managed by update-agent"},
+		},
+		"idle_node": {
+			node: explainNode("a", nil, map[string]string{
+				constants.AnnotationRebootNeeded: constants.False,
+				constants.AnnotationStatus:       "UPDATE_STATUS_IDLE",
+			}),
+			expected: []string{`does not need a reboot, update_engine status is "UPDATE_STATUS_IDLE"`},
+		},
+		"paused_node": {
+			node: explainNode("a", nil, map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+				constants.AnnotationRebootPaused: constants.True,
+			}),
+			expected: []string{"paused"},
+		},
+		"node_waiting_for_before_reboot_annotations": {
+			node: explainNode("a", map[string]string{constants.LabelBeforeReboot: constants.True},
+				map[string]string{constants.AnnotationRebootNeeded: constants.True, "check-a": constants.True}),
+			config: &updatev1alpha1.UpdatePolicySpec{
+				BeforeRebootAnnotations: []string{"check-a", "check-b"},
+			},
+			expected: []string{`waits for before-reboot annotations to be set to "true": "check-b"`},
+		},
+		"node_failing_after_reboot_check": {
+			node: explainNode("a", map[string]string{constants.LabelAfterReboot: constants.True},
+				map[string]string{constants.AnnotationRebootNeeded: constants.False, "check-a": constants.Failed}),
+			config: &updatev1alpha1.UpdatePolicySpec{
+				AfterRebootAnnotations: []string{"check-a"},
+			},
+			expected: []string{`failed after-reboot checks`, `"check-a" is set to "failed"`},
+		},
+		"node_refusing_drain": {
+			node: explainNode("a", nil, map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+				constants.AnnotationOkToReboot:   constants.True,
+				constants.AnnotationDrainRefused: "pod uses local storage",
+			}),
+			expected: []string{"refused to drain the node: pod uses local storage"},
+		},
+		"node_backed_off_after_refused_drain": {
+			node: explainNode("a", nil, map[string]string{
+				constants.AnnotationRebootNeeded:      constants.True,
+				constants.AnnotationOkToReboot:        constants.False,
+				constants.AnnotationDrainRefused:      "pod uses local storage",
+				constants.AnnotationRebootAbortedTime: "2021-03-02T11:00:00Z",
+			}),
+			config:   &updatev1alpha1.UpdatePolicySpec{},
+			expected: []string{"refused to drain the node: pod uses local storage", "drain refused backoff"},
+		},
+		"halted_reboots_and_unknown_configuration": {
+			node: explainNode("a", nil, wantsReboot),
+			nodes: []corev1.Node{
+				explainNode("b", nil, map[string]string{
+					constants.AnnotationRebootNeeded: constants.False,
+					constants.AnnotationRebootFailed: constants.True,
+				}),
+			},
+			expected: []string{`reboot of node "b" failed`, "Configuration of update-operator is unknown"},
+		},
+		"outside_reboot_window_with_too_many_unavailable_nodes": {
+			node: explainNode("a", nil, wantsReboot),
+			nodes: []corev1.Node{
+				explainNode("b", map[string]string{constants.LabelBeforeReboot: constants.True}, idle),
+				explainNode("c", nil, idle),
+			},
+			config: &updatev1alpha1.UpdatePolicySpec{
+				RebootWindows:  []string{"Mon 10:00 1h UTC"},
+				MaxUnavailable: &maxUnavailable,
+			},
+			expected: []string{"outside of the reboot windows", `1 of maximum 1 nodes are rebooting or not ready: "b"`},
+		},
+		"control_plane_node_waiting_for_workers": {
+			node:     explainNode("a", map[string]string{operator.DefaultControlPlaneSelector: ""}, wantsReboot),
+			nodes:    []corev1.Node{explainNode("b", nil, wantsReboot)},
+			config:   &updatev1alpha1.UpdatePolicySpec{},
+			expected: []string{"rebooted after worker nodes, 1 worker nodes may reboot now"},
+		},
+		"control_plane_node_not_waiting_for_workers_outside_reboot_windows": {
+			node: explainNode("a", map[string]string{operator.DefaultControlPlaneSelector: ""}, wantsReboot),
+			nodes: []corev1.Node{
+				explainNode("b", map[string]string{"pool": "night"}, wantsReboot),
+			},
+			config: &updatev1alpha1.UpdatePolicySpec{
+				PoolRebootWindows: []updatev1alpha1.PoolRebootWindows{
+					{Selector: "pool=night", Windows: []string{"Mon 22:00 1h UTC"}},
+				},
+			},
+			expected: []string{"waits to be chosen for reboot"},
+		},
+		"node_waiting_to_be_chosen": {
+			node:     explainNode("a", nil, wantsReboot),
+			config:   &updatev1alpha1.UpdatePolicySpec{},
+			expected: []string{"waits to be chosen for reboot"},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Explained node is always one of all nodes.
+			nodes := append([]corev1.Node{c.node}, c.nodes...)
+
+			reasons, err := operator.ExplainNode(c.node, nodes, c.config, now)
+			if err != nil {
+				t.Fatalf("Explaining node: %v", err)
+			}
+
+			output := strings.Join(reasons, "\n")
+
+			for _, expected := range c.expected {
+				if !strings.Contains(output, expected) {
+					t.Fatalf("Expected reasons to contain %q, got:\n%s", expected, output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+// rebootFailedSelector is a selector for the annotation set by the update-operator when
+// node failed to come back from the reboot in time. As long as any node has this annotation,
+// reboots are halted cluster-wide.
+var rebootFailedSelector = fields.OneTermEqualSelector(constants.AnnotationRebootFailed, constants.True)
+
+// rebootFailed returns true if the update-agent started the reboot of the node more than the deadline
+// ago and the node has not come back ready with reboot finished since then. The deadline starts
+// when the agent sets the reboot-in-progress annotation, so the time the node waits for the agent
+// or spends in before-reboot checks does not count.
+func rebootFailed(node corev1.Node, deadline time.Duration, now time.Time) bool {
+	if node.Annotations[constants.AnnotationOkToReboot] != constants.True {
+		return false
+	}
+
+	if justRebootedSelector.Matches(fields.Set(node.Annotations)) && isNodeReady(node) {
+		return false
+	}
+
+	// Reboot aborted by the agent is released instead.
+	if rebootAbortedSelector.Matches(fields.Set(node.Annotations)) {
+		return false
+	}
+
+	okToRebootTime, err := time.Parse(time.RFC3339, node.Annotations[constants.AnnotationOkToRebootTime])
+	if err != nil {
+		// Reboots started by older versions of the operator are not tracked.
+		return false
+	}
+
+	inProgressTime, err := time.Parse(time.RFC3339, node.Annotations[constants.AnnotationRebootInProgressTime])
+	if err != nil {
+		// Reboots started by older versions of the agent are not tracked.
+		return false
+	}
+
+	// Annotation left from the previous reboot, the agent has not started this reboot yet.
+	if inProgressTime.Before(okToRebootTime) {
+		return false
+	}
+
+	return now.Sub(inProgressTime) > deadline
+}
+
+// detectRebootFailures finds nodes which failed to come back ready from the reboot in time and
+// annotates them with reboot-failed=true annotation, which halts all further reboots until
+// an administrator clears the annotation by setting it to "false".
+//
+// Nodes, for which the annotation is already set, are skipped, so the administrator can clear the
+// halt before the node recovers. The annotation is removed when the node is allowed to reboot again.
+//
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) detectRebootFailures(nodes *nodeSnapshot) error {
+	if k.rebootDeadline == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	for i := range nodes.items {
+		node := nodes.items[i]
+
+		if _, ok := node.Annotations[constants.AnnotationRebootFailed]; ok {
+			continue
+		}
+
+		if !rebootFailed(node, k.rebootDeadline, now) {
+			continue
+		}
+
+		klog.Errorf("Node %q did not come back ready from the reboot within %v, halting all reboots",
+			node.Name, k.rebootDeadline)
+
+		err := nodes.setAnnotations(node.Name, map[string]string{
+			constants.AnnotationRebootFailed: constants.True,
+		})
+		if err != nil {
+			return fmt.Errorf("marking node %q as failed: %w", node.Name, err)
+		}
+
+		k.nodeEventf(&node, corev1.EventTypeWarning, "RebootFailed",
+			"Node did not come back ready from the reboot within %v, halting all reboots. Set annotation %q "+
+				"to %q to resume", k.rebootDeadline, constants.AnnotationRebootFailed, constants.False)
+	}
+
+	return nil
+}
+
+// rebootsHalted returns true if any of the given nodes failed to reboot and the failure has not been
+// cleared by an administrator yet.
+func rebootsHalted(nodes []corev1.Node) bool {
+	failedNodes := k8sutil.FilterNodesByAnnotation(nodes, rebootFailedSelector)
+
+	for _, n := range failedNodes {
+		klog.Warningf("Reboots are halted, node %q failed to reboot. Set annotation %q to %q to resume",
+			n.Name, constants.AnnotationRebootFailed, constants.False)
+	}
+
+	return len(failedNodes) > 0
+}
@@ -0,0 +1,59 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_rebootFailed(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	deadline := time.Hour
+
+	node := func(inProgressTime time.Time, rebootNeeded string, ready corev1.ConditionStatus) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node",
+				Annotations: map[string]string{
+					constants.AnnotationOkToReboot:           constants.True,
+					constants.AnnotationOkToRebootTime:       inProgressTime.Add(-time.Hour).UTC().Format(time.RFC3339),
+					constants.AnnotationRebootInProgressTime: inProgressTime.UTC().Format(time.RFC3339),
+					constants.AnnotationRebootNeeded:         rebootNeeded,
+					constants.AnnotationRebootInProgress:     constants.False,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	if rebootFailed(node(now.Add(-time.Minute), constants.True, corev1.ConditionFalse), deadline, now) {
+		t.Errorf("Node rebooting within deadline should not be considered failed")
+	}
+
+	if !rebootFailed(node(now.Add(-2*time.Hour), constants.True, corev1.ConditionFalse), deadline, now) {
+		t.Errorf("Node still rebooting after deadline should be considered failed")
+	}
+
+	if !rebootFailed(node(now.Add(-2*time.Hour), constants.False, corev1.ConditionFalse), deadline, now) {
+		t.Errorf("Node which rebooted, but is not ready after deadline should be considered failed")
+	}
+
+	if rebootFailed(node(now.Add(-2*time.Hour), constants.False, corev1.ConditionTrue), deadline, now) {
+		t.Errorf("Node which rebooted and is ready should not be considered failed")
+	}
+
+	waitingForAgent := node(now.Add(-3*time.Hour), constants.True, corev1.ConditionTrue)
+	waitingForAgent.Annotations[constants.AnnotationOkToRebootTime] = now.Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	if rebootFailed(waitingForAgent, deadline, now) {
+		t.Errorf("Node with reboot not started by the agent yet should not be considered failed")
+	}
+}
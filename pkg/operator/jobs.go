@@ -0,0 +1,265 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+const (
+	// Phases of the reboot, in which hooks like Jobs or webhooks run.
+	rebootPhaseBefore = "before-reboot"
+	rebootPhaseAfter  = "after-reboot"
+
+	// Number of bytes of the node name hash used in the label of reboot Jobs.
+	nodeNameHashBytes = 16
+)
+
+// rebootJob runs a Job created from the template on each node in the before-reboot or after-reboot
+// phase and sets the phase annotation on the node once the Job succeeds.
+type rebootJob struct {
+	phase      string
+	req        *labels.Requirement
+	annotation string
+	template   *batchv1.Job
+}
+
+// newRebootJob creates rebootJob for the given phase from the given template. If template is
+// nil, nil is returned.
+func newRebootJob(phase string, template *batchv1.Job) *rebootJob {
+	if template == nil {
+		return nil
+	}
+
+	j := &rebootJob{
+		phase:    phase,
+		template: template,
+	}
+
+	switch phase {
+	case rebootPhaseBefore:
+		j.req = beforeRebootReq
+		j.annotation = constants.AnnotationBeforeRebootJobSucceeded
+	case rebootPhaseAfter:
+		j.req = afterRebootReq
+		j.annotation = constants.AnnotationAfterRebootJobSucceeded
+	}
+
+	return j
+}
+
+// namespace returns the namespace of Jobs, which is the namespace of the template or the given
+// operator namespace, if the template has no namespace.
+func (j *rebootJob) namespace(operatorNamespace string) string {
+	if j.template.Namespace != "" {
+		return j.template.Namespace
+	}
+
+	return operatorNamespace
+}
+
+// jobFor creates a Job from the template, pinned to the given node.
+func (j *rebootJob) jobFor(node corev1.Node, namespace string) *batchv1.Job {
+	job := j.template.DeepCopy()
+	job.Namespace = j.namespace(namespace)
+
+	job.GenerateName = job.Name
+	if job.GenerateName == "" {
+		job.GenerateName = j.phase + "-"
+	}
+
+	job.Name = ""
+
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+
+	job.Labels[constants.LabelRebootJob] = j.phase
+	job.Labels[constants.LabelRebootJobNodeHash] = nodeNameHash(node.Name)
+
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+
+	job.Annotations[constants.AnnotationRebootJobNode] = node.Name
+
+	job.Spec.Template.Spec.NodeName = node.Name
+
+	return job
+}
+
+// nodeNameHash returns hash of the given node name, which can be used as a label value. Node names
+// may be longer than 63 characters allowed in label values.
+func nodeNameHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+
+	return hex.EncodeToString(sum[:nodeNameHashBytes])
+}
+
+// jobFinished returns whether the given Job has finished and whether it has succeeded.
+func jobFinished(job batchv1.Job) (finished, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+
+	return false, false
+}
+
+// runRebootJobs runs configured before-reboot and after-reboot Jobs on nodes in the matching phase.
+//
+// When the Job for a node succeeds, the phase annotation is set to "true" on the node, so the node
+// can leave the phase. When the Job fails, the phase annotation is set to "failed", so the phase
+// fails the same way as when any configured annotation is set to a failure value: the reboot of
+// a node in the before-reboot phase is aborted and a node in the after-reboot phase is marked as
+// failed, which halts all further reboots.
+//
+// Finished Jobs and Jobs of nodes, which are no longer in the phase, are deleted.
+//
+// If there is an error getting the list of Jobs, an error is immediately returned. Errors creating,
+// deleting or handling Jobs of a single node are logged and the node is retried in the next
+// reconciliation, so they do not block Jobs of other nodes.
+func (k *Kontroller) runRebootJobs(nodes *nodeSnapshot) error {
+	for _, j := range k.rebootJobs {
+		if err := k.runRebootJob(nodes, j); err != nil {
+			return fmt.Errorf("running %s jobs: %w", j.phase, err)
+		}
+	}
+
+	return nil
+}
+
+// runRebootJob runs Jobs for a single phase.
+func (k *Kontroller) runRebootJob(nodes *nodeSnapshot, j *rebootJob) error {
+	phaseNodes := map[string]corev1.Node{}
+
+	for _, node := range k8sutil.FilterNodesByRequirement(nodes.items, j.req) {
+		phaseNodes[node.Name] = node
+	}
+
+	jobList, err := k.kc.BatchV1().Jobs(j.namespace(k.namespace)).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{constants.LabelRebootJob: j.phase}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("listing jobs: %w", err)
+	}
+
+	jobs := map[string]struct{}{}
+
+	for _, job := range jobList.Items {
+		nodeName := job.Annotations[constants.AnnotationRebootJobNode]
+		jobs[nodeName] = struct{}{}
+
+		node, ok := phaseNodes[nodeName]
+		if !ok {
+			klog.Infof("Deleting %s job %q, node %q is no longer in %s phase", j.phase, job.Name, nodeName, j.phase)
+
+			if err := k.deleteJob(job); err != nil {
+				klog.Errorf("Failed deleting %s job %q of node %q: %v", j.phase, job.Name, nodeName, err)
+			}
+
+			continue
+		}
+
+		if err := k.handleRebootJob(nodes, j, node, job); err != nil {
+			klog.Errorf("Failed handling %s job %q of node %q: %v", j.phase, job.Name, nodeName, err)
+		}
+	}
+
+	for _, node := range phaseNodes {
+		if _, ok := jobs[node.Name]; ok {
+			continue
+		}
+
+		// Failed Job is run again only when the node enters the phase again.
+		if node.Annotations[j.annotation] == constants.True || node.Annotations[j.annotation] == constants.Failed ||
+			node.Annotations[constants.AnnotationRebootFailed] == constants.True {
+			continue
+		}
+
+		if k.dryRun {
+			klog.Infof("Dry run: would create %s job for node %q", j.phase, node.Name)
+
+			continue
+		}
+
+		job := j.jobFor(node, k.namespace)
+
+		job, err := k.kc.BatchV1().Jobs(job.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+		if err != nil {
+			klog.Errorf("Failed creating %s job for node %q: %v", j.phase, node.Name, err)
+
+			continue
+		}
+
+		klog.Infof("Created %s job %q for node %q", j.phase, job.Name, node.Name)
+	}
+
+	return nil
+}
+
+// handleRebootJob checks if the given Job has finished and updates the node accordingly.
+func (k *Kontroller) handleRebootJob(nodes *nodeSnapshot, j *rebootJob, node corev1.Node, job batchv1.Job) error {
+	finished, succeeded := jobFinished(job)
+	if !finished {
+		return nil
+	}
+
+	value := constants.True
+
+	if succeeded {
+		klog.Infof("The %s job %q for node %q succeeded", j.phase, job.Name, node.Name)
+	} else {
+		// Failed Job fails the phase the same way as any other failed check.
+		klog.Errorf("The %s job %q for node %q failed", j.phase, job.Name, node.Name)
+
+		value = constants.Failed
+
+		k.nodeEventf(&node, corev1.EventTypeWarning, "RebootJobFailed", "The %s job %q failed", j.phase, job.Name)
+	}
+
+	if err := nodes.setAnnotations(node.Name, map[string]string{j.annotation: value}); err != nil {
+		return fmt.Errorf("setting annotations on node %q: %w", node.Name, err)
+	}
+
+	return k.deleteJob(job)
+}
+
+// deleteJob deletes the given Job together with its pods. In dry run, the deletion is only logged.
+func (k *Kontroller) deleteJob(job batchv1.Job) error {
+	if k.dryRun {
+		klog.Infof("Dry run: would delete job %q", job.Name)
+
+		return nil
+	}
+
+	propagation := metav1.DeletePropagationBackground
+
+	err := k.kc.BatchV1().Jobs(job.Namespace).Delete(context.TODO(), job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting job %q: %w", job.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_rebootJob_jobFor_pins_job_to_node(t *testing.T) {
+	t.Parallel()
+
+	template := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "check-",
+			Labels: map[string]string{"app": "check"},
+		},
+	}
+
+	j := newRebootJob(rebootPhaseBefore, template)
+
+	job := j.jobFor(corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, "reboot-coordinator")
+
+	if job.Namespace != "reboot-coordinator" {
+		t.Errorf("Expected job in operator namespace, got %q", job.Namespace)
+	}
+
+	if job.Name != "" || job.GenerateName != "check-" {
+		t.Errorf("Expected job name to be generated from template name, got name %q and generate name %q",
+			job.Name, job.GenerateName)
+	}
+
+	if job.Spec.Template.Spec.NodeName != "foo" {
+		t.Errorf("Expected job to be pinned to node %q, got %q", "foo", job.Spec.Template.Spec.NodeName)
+	}
+
+	expectedLabels := map[string]string{
+		"app":                            "check",
+		constants.LabelRebootJob:         rebootPhaseBefore,
+		constants.LabelRebootJobNodeHash: nodeNameHash("foo"),
+	}
+
+	for k, v := range expectedLabels {
+		if job.Labels[k] != v {
+			t.Errorf("Expected label %q to be %q, got %q", k, v, job.Labels[k])
+		}
+	}
+
+	if node := job.Annotations[constants.AnnotationRebootJobNode]; node != "foo" {
+		t.Errorf("Expected node annotation to be %q, got %q", "foo", node)
+	}
+
+	if len(template.Labels) != 1 || template.Annotations != nil || template.Spec.Template.Spec.NodeName != "" {
+		t.Errorf("Template should not be modified")
+	}
+}
+
+func Test_rebootJob_jobFor_labels_job_with_valid_label_value_for_long_node_names(t *testing.T) {
+	t.Parallel()
+
+	name := strings.Repeat("a", 100) + ".example.com"
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+
+	job := newRebootJob(rebootPhaseBefore, &batchv1.Job{}).jobFor(node, "reboot-coordinator")
+
+	if errs := validation.IsValidLabelValue(job.Labels[constants.LabelRebootJobNodeHash]); len(errs) != 0 {
+		t.Fatalf("Expected valid node label value, got: %v", errs)
+	}
+
+	if node := job.Annotations[constants.AnnotationRebootJobNode]; node != name {
+		t.Fatalf("Expected node annotation to be %q, got %q", name, node)
+	}
+}
+
+func Test_jobFinished(t *testing.T) {
+	t.Parallel()
+
+	job := func(t batchv1.JobConditionType, status corev1.ConditionStatus) batchv1.Job {
+		return batchv1.Job{
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{Type: t, Status: status}},
+			},
+		}
+	}
+
+	if finished, _ := jobFinished(batchv1.Job{}); finished {
+		t.Errorf("Job without conditions should not be finished")
+	}
+
+	if finished, succeeded := jobFinished(job(batchv1.JobComplete, corev1.ConditionTrue)); !finished || !succeeded {
+		t.Errorf("Complete job should be finished and succeeded")
+	}
+
+	if finished, succeeded := jobFinished(job(batchv1.JobFailed, corev1.ConditionTrue)); !finished || succeeded {
+		t.Errorf("Failed job should be finished and not succeeded")
+	}
+
+	if finished, _ := jobFinished(job(batchv1.JobFailed, corev1.ConditionFalse)); finished {
+		t.Errorf("Job with false condition should not be finished")
+	}
+}
+
+func Test_runRebootJob_only_manages_jobs_in_jobs_namespace(t *testing.T) {
+	t.Parallel()
+
+	job := func(namespace string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "check",
+				Namespace:   namespace,
+				Labels:      map[string]string{constants.LabelRebootJob: rebootPhaseBefore},
+				Annotations: map[string]string{constants.AnnotationRebootJobNode: "foo"},
+			},
+		}
+	}
+
+	kc := fake.NewSimpleClientset(job("reboot-coordinator"), job("other"))
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	// No node is in the before-reboot phase, so Jobs managed by the operator are deleted.
+	nodes, err := newNodeSnapshot(nil, indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	k := &Kontroller{kc: kc, namespace: "reboot-coordinator"}
+
+	if err := k.runRebootJob(nodes, newRebootJob(rebootPhaseBefore, &batchv1.Job{})); err != nil {
+		t.Fatalf("Running reboot jobs: %v", err)
+	}
+
+	jobs, err := kc.BatchV1().Jobs(corev1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Listing jobs: %v", err)
+	}
+
+	if len(jobs.Items) != 1 || jobs.Items[0].Namespace != "other" {
+		t.Fatalf("Expected only job in operator namespace to be deleted, got %v", jobs.Items)
+	}
+}
+
+func Test_runRebootJob_creates_jobs_for_other_nodes_when_creating_job_fails(t *testing.T) {
+	t.Parallel()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	for _, name := range []string{"foo", "bar"} {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{constants.LabelBeforeReboot: constants.True},
+			},
+		}
+
+		if err := indexer.Add(node); err != nil {
+			t.Fatalf("Adding node: %v", err)
+		}
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	nodes, err := newNodeSnapshot(nil, indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	kc := fake.NewSimpleClientset()
+
+	kc.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job, _ := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		if job.Spec.Template.Spec.NodeName == "foo" {
+			return true, nil, fmt.Errorf("creating job")
+		}
+
+		// Fake client does not generate names, so give each Job a unique name.
+		job.Name = job.Spec.Template.Spec.NodeName
+
+		return false, nil, nil
+	})
+
+	k := &Kontroller{kc: kc, namespace: "reboot-coordinator"}
+
+	if err := k.runRebootJob(nodes, newRebootJob(rebootPhaseBefore, &batchv1.Job{})); err != nil {
+		t.Fatalf("Expected error creating job for a single node to not fail, got: %v", err)
+	}
+
+	jobs, err := kc.BatchV1().Jobs(corev1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Listing jobs: %v", err)
+	}
+
+	if len(jobs.Items) != 1 || jobs.Items[0].Annotations[constants.AnnotationRebootJobNode] != "bar" {
+		t.Fatalf("Expected job to be created for node %q, got %v", "bar", jobs.Items)
+	}
+}
+
+func Test_handleRebootJob_fails_the_phase_when_job_fails(t *testing.T) {
+	t.Parallel()
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "foo",
+			Labels:      map[string]string{constants.LabelBeforeReboot: constants.True},
+			Annotations: map[string]string{},
+		},
+	}
+
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "check", Namespace: "reboot-coordinator"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	kc := fake.NewSimpleClientset(node, &job)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	if err := indexer.Add(node); err != nil {
+		t.Fatalf("Adding node: %v", err)
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	nodes, err := newNodeSnapshot(kc.CoreV1().Nodes(), indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	j := newRebootJob(rebootPhaseBefore, &batchv1.Job{})
+	k := &Kontroller{kc: kc, er: record.NewFakeRecorder(1), beforeRebootAnnotations: []string{j.annotation}}
+
+	if err := k.handleRebootJob(nodes, j, nodes.items[0], job); err != nil {
+		t.Fatalf("Handling job: %v", err)
+	}
+
+	if _, ok := nodes.items[0].Annotations[constants.AnnotationRebootFailed]; ok {
+		t.Fatalf("Expected failed before-reboot job to not halt all reboots")
+	}
+
+	if reason := phaseFailure(nodes.items[0], k.phases()[0], time.Now()); reason == "" {
+		t.Fatalf("Expected node to fail the before-reboot phase, got annotations %v", nodes.items[0].Annotations)
+	}
+}
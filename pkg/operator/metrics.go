@@ -0,0 +1,173 @@
+package operator
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+const (
+	metricsPrefix = "flatcar_linux_update_operator_"
+
+	// Phases reported in metrics, in addition to before-reboot and after-reboot phases.
+	metricsPhaseWantsReboot = "wants-reboot"
+	metricsPhaseRebooting   = "rebooting"
+)
+
+// metricsPhases maps update phases of nodes to phases reported in metrics.
+var metricsPhases = map[updatev1alpha1.NodeUpdatePhase]string{
+	updatev1alpha1.NodeUpdatePhaseRebootNeeded: metricsPhaseWantsReboot,
+	updatev1alpha1.NodeUpdatePhaseBeforeReboot: rebootPhaseBefore,
+	updatev1alpha1.NodeUpdatePhaseRebooting:    metricsPhaseRebooting,
+	updatev1alpha1.NodeUpdatePhaseAfterReboot:  rebootPhaseAfter,
+}
+
+// metricsPhaseStartAnnotations maps phases reported in metrics to annotations holding the time
+// the node entered the phase.
+var metricsPhaseStartAnnotations = map[string]string{
+	metricsPhaseWantsReboot: constants.AnnotationRebootNeededTime,
+	rebootPhaseBefore:       constants.AnnotationBeforeRebootTime,
+	metricsPhaseRebooting:   constants.AnnotationOkToRebootTime,
+	rebootPhaseAfter:        constants.AnnotationAfterRebootTime,
+}
+
+// operatorMetrics are metrics reported by the operator.
+type operatorMetrics struct {
+	registry *prometheus.Registry
+	// In dry run, nodes do not really complete phases, so phase durations are not recorded.
+	dryRun bool
+
+	nodes                *prometheus.GaugeVec
+	nodeVersions         *prometheus.GaugeVec
+	insideRebootWindow   *prometheus.GaugeVec
+	phaseDuration        *prometheus.HistogramVec
+	reconciliationErrors *prometheus.CounterVec
+
+	// Versions and nodes reported in the last observation, so the ones which disappeared are removed.
+	reportedVersions map[string]struct{}
+	reportedNodes    map[string]struct{}
+}
+
+// newOperatorMetrics creates and registers metrics reported by the operator.
+func newOperatorMetrics(dryRun bool) *operatorMetrics {
+	m := &operatorMetrics{
+		registry: prometheus.NewRegistry(),
+		dryRun:   dryRun,
+		nodes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricsPrefix + "nodes",
+			Help: "Number of managed nodes in each phase of the update.",
+		}, []string{"phase"}),
+		nodeVersions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricsPrefix + "node_versions",
+			Help: "Number of managed nodes running each version of Flatcar Container Linux.",
+		}, []string{"version"}),
+		insideRebootWindow: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricsPrefix + "inside_reboot_window",
+			Help: "Whether the current time is inside the reboot windows of each managed node.",
+		}, []string{"node"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: metricsPrefix + "phase_duration_seconds",
+			Help: "Time nodes spent in each phase of the update before completing it.",
+			// From a second to a day and a half.
+			Buckets: prometheus.ExponentialBuckets(1, 2, 18), //nolint:mnd // Explained above.
+		}, []string{"phase"}),
+		reconciliationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: metricsPrefix + "reconciliation_errors_total",
+			Help: "Number of failed reconciliations by the failed step.",
+		}, []string{"step"}),
+		reportedVersions: map[string]struct{}{},
+		reportedNodes:    map[string]struct{}{},
+	}
+
+	m.registry.MustRegister(m.nodes, m.nodeVersions, m.insideRebootWindow, m.phaseDuration, m.reconciliationErrors)
+
+	return m
+}
+
+// MetricsHandler returns HTTP handler serving operator metrics in the Prometheus text format.
+func (k *Kontroller) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(k.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// observeNodes updates metrics describing the current state of the given nodes. insideRebootWindow
+// tells if the current time is inside the reboot windows of the given node.
+func (m *operatorMetrics) observeNodes(nodes []corev1.Node, insideRebootWindow func(corev1.Node) bool) {
+	phases := map[string]int{}
+	versions := map[string]int{}
+	windows := map[string]bool{}
+
+	for _, node := range managedNodes(nodes) {
+		if phase, ok := metricsPhases[NodePhase(node)]; ok {
+			phases[phase]++
+		}
+
+		if version := node.Labels[constants.LabelVersion]; version != "" {
+			versions[version]++
+		}
+
+		windows[node.Name] = insideRebootWindow(node)
+	}
+
+	for _, phase := range metricsPhases {
+		m.nodes.WithLabelValues(phase).Set(float64(phases[phase]))
+	}
+
+	// Series are updated in place and only the ones which disappeared are removed, so scrapes never
+	// see them missing.
+	for version, count := range versions {
+		m.nodeVersions.WithLabelValues(version).Set(float64(count))
+	}
+
+	for version := range m.reportedVersions {
+		if _, ok := versions[version]; !ok {
+			m.nodeVersions.DeleteLabelValues(version)
+		}
+	}
+
+	for name, inside := range windows {
+		window := 0.0
+		if inside {
+			window = 1
+		}
+
+		m.insideRebootWindow.WithLabelValues(name).Set(window)
+	}
+
+	for name := range m.reportedNodes {
+		if _, ok := windows[name]; !ok {
+			m.insideRebootWindow.DeleteLabelValues(name)
+		}
+	}
+
+	m.reportedVersions = map[string]struct{}{}
+	m.reportedNodes = map[string]struct{}{}
+
+	for version := range versions {
+		m.reportedVersions[version] = struct{}{}
+	}
+
+	for name := range windows {
+		m.reportedNodes[name] = struct{}{}
+	}
+}
+
+// observePhaseCompleted records time the given node spent in the given phase, which it has just
+// completed. Nodes without the phase start time are not recorded.
+func (m *operatorMetrics) observePhaseCompleted(phase string, node corev1.Node, now time.Time) {
+	if m.dryRun {
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, node.Annotations[metricsPhaseStartAnnotations[phase]])
+	if err != nil {
+		return
+	}
+
+	m.phaseDuration.WithLabelValues(phase).Observe(now.Sub(start).Seconds())
+}
@@ -0,0 +1,137 @@
+package operator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+// gatherMetrics returns metrics served by the given handler in the Prometheus text format.
+func gatherMetrics(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d serving metrics, got %d", http.StatusOK, recorder.Code)
+	}
+
+	return recorder.Body.String()
+}
+
+//nolint:funlen // Just many expected metrics.
+func Test_operatorMetrics_observeNodes(t *testing.T) {
+	t.Parallel()
+
+	node := func(name, version string, labels, annotations map[string]string) corev1.Node {
+		n := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{constants.LabelVersion: version},
+				Annotations: annotations,
+			},
+		}
+
+		for k, v := range labels {
+			n.Labels[k] = v
+		}
+
+		return n
+	}
+
+	insideRebootWindow := func(node corev1.Node) bool {
+		return node.Labels["pool"] == "night"
+	}
+
+	m := newOperatorMetrics(false)
+	handler := (&Kontroller{metrics: m}).MetricsHandler()
+
+	m.observeNodes([]corev1.Node{
+		node("a", "2765.2.0", nil, map[string]string{constants.AnnotationRebootNeeded: constants.True}),
+		node("b", "2765.2.0", map[string]string{constants.LabelBeforeReboot: constants.True, "pool": "night"},
+			map[string]string{constants.AnnotationRebootNeeded: constants.True}),
+		node("c", "2765.2.1", nil, map[string]string{constants.AnnotationRebootNeeded: constants.False}),
+		// Unmanaged node is not reported.
+		node("d", "2605.12.0", nil, nil),
+	}, insideRebootWindow)
+
+	output := gatherMetrics(t, handler)
+
+	for _, expected := range []string{
+		`flatcar_linux_update_operator_nodes{phase="wants-reboot"} 1`,
+		`flatcar_linux_update_operator_nodes{phase="before-reboot"} 1`,
+		`flatcar_linux_update_operator_nodes{phase="rebooting"} 0`,
+		`flatcar_linux_update_operator_node_versions{version="2765.2.0"} 2`,
+		`flatcar_linux_update_operator_node_versions{version="2765.2.1"} 1`,
+		`flatcar_linux_update_operator_inside_reboot_window{node="a"} 0`,
+		`flatcar_linux_update_operator_inside_reboot_window{node="b"} 1`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Fatalf("Expected metric %q, got:\n%s", expected, output)
+		}
+	}
+
+	for _, unexpected := range []string{"2605.12.0", `node="d"`} {
+		if strings.Contains(output, unexpected) {
+			t.Fatalf("Expected unmanaged node to not be reported, got:\n%s", output)
+		}
+	}
+
+	// Versions and nodes which disappeared are removed.
+	m.observeNodes([]corev1.Node{
+		node("c", "2765.2.1", nil, map[string]string{constants.AnnotationRebootNeeded: constants.False}),
+	}, insideRebootWindow)
+
+	output = gatherMetrics(t, handler)
+
+	for _, unexpected := range []string{`version="2765.2.0"`, `node="a"`, `node="b"`} {
+		if strings.Contains(output, unexpected) {
+			t.Fatalf("Expected %s to be removed from metrics, got:\n%s", unexpected, output)
+		}
+	}
+}
+
+func Test_operatorMetrics_observePhaseCompleted(t *testing.T) {
+	t.Parallel()
+
+	m := newOperatorMetrics(false)
+
+	start := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				constants.AnnotationBeforeRebootTime: start.Format(time.RFC3339),
+			},
+		},
+	}
+
+	m.observePhaseCompleted(rebootPhaseBefore, node, start.Add(90*time.Second))
+	// Node without the phase start time is not recorded.
+	m.observePhaseCompleted(rebootPhaseAfter, node, start.Add(90*time.Second))
+
+	output := gatherMetrics(t, (&Kontroller{metrics: m}).MetricsHandler())
+
+	for _, expected := range []string{
+		`flatcar_linux_update_operator_phase_duration_seconds_bucket{phase="before-reboot",le="64"} 0`,
+		`flatcar_linux_update_operator_phase_duration_seconds_bucket{phase="before-reboot",le="128"} 1`,
+		`flatcar_linux_update_operator_phase_duration_seconds_sum{phase="before-reboot"} 90`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Fatalf("Expected metric %q, got:\n%s", expected, output)
+		}
+	}
+
+	if strings.Contains(output, rebootPhaseAfter) {
+		t.Fatalf("Expected phase without start time to not be recorded, got:\n%s", output)
+	}
+}
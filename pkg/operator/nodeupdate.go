@@ -0,0 +1,244 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+// nodeUpdateResource is the NodeUpdate resource maintained by the operator.
+var nodeUpdateResource = updatev1alpha1.SchemeGroupVersion.WithResource(updatev1alpha1.NodeUpdateResource)
+
+// NodePhase returns the phase of the update of the given node, computed from the labels and
+// annotations set by the update-agent and the update-operator.
+func NodePhase(node corev1.Node) updatev1alpha1.NodeUpdatePhase {
+	annotations := node.Annotations
+
+	switch {
+	case annotations[constants.AnnotationRebootFailed] == constants.True:
+		return updatev1alpha1.NodeUpdatePhaseFailed
+	case node.Labels[constants.LabelAfterReboot] == constants.True:
+		return updatev1alpha1.NodeUpdatePhaseAfterReboot
+	case annotations[constants.AnnotationOkToReboot] == constants.True:
+		// Node which has just rebooted is labeled for after-reboot checks in the next step.
+		if justRebootedSelector.Matches(fields.Set(annotations)) {
+			return updatev1alpha1.NodeUpdatePhaseAfterReboot
+		}
+
+		return updatev1alpha1.NodeUpdatePhaseRebooting
+	case node.Labels[constants.LabelBeforeReboot] == constants.True:
+		return updatev1alpha1.NodeUpdatePhaseBeforeReboot
+	case annotations[constants.AnnotationRebootNeeded] != constants.True:
+		return updatev1alpha1.NodeUpdatePhaseIdle
+	case annotations[constants.AnnotationRebootPaused] == constants.True:
+		return updatev1alpha1.NodeUpdatePhasePaused
+	default:
+		return updatev1alpha1.NodeUpdatePhaseRebootNeeded
+	}
+}
+
+// nodeError returns the error reported on the given node. If there is no error, empty string is returned.
+func nodeError(node corev1.Node) string {
+	if node.Annotations[constants.AnnotationRebootFailed] == constants.True {
+		return "Reboot failed, all reboots are halted"
+	}
+
+	if reason := node.Annotations[constants.AnnotationDrainRefused]; reason != "" {
+		return fmt.Sprintf("Drain refused: %s", reason)
+	}
+
+	return ""
+}
+
+// nodeUpdateStatus returns the given NodeUpdate status updated with the current state of the node.
+func nodeUpdateStatus(status updatev1alpha1.NodeUpdateStatus, node corev1.Node,
+	now time.Time) updatev1alpha1.NodeUpdateStatus {
+	status = *status.DeepCopy()
+
+	status.CurrentVersion = node.Labels[constants.LabelVersion]
+	status.TargetVersion = node.Annotations[constants.AnnotationNewVersion]
+	status.UpdateEngineStatus = node.Annotations[constants.AnnotationStatus]
+
+	if phase := NodePhase(node); phase != status.Phase {
+		status.Phase = phase
+		status.LastTransitionTime = metav1.NewTime(now)
+		status.Transitions = setTransition(status.Transitions, phase, status.LastTransitionTime)
+	}
+
+	if nodeErr := nodeError(node); nodeErr != "" && nodeErr != status.LastError {
+		errTime := metav1.NewTime(now)
+
+		status.LastError = nodeErr
+		status.LastErrorTime = &errTime
+	}
+
+	return status
+}
+
+// setTransition sets the time of entering the given phase in the given transitions.
+func setTransition(transitions []updatev1alpha1.NodeUpdateTransition, phase updatev1alpha1.NodeUpdatePhase,
+	t metav1.Time) []updatev1alpha1.NodeUpdateTransition {
+	for i := range transitions {
+		if transitions[i].Phase == phase {
+			transitions[i].Time = t
+
+			return transitions
+		}
+	}
+
+	return append(transitions, updatev1alpha1.NodeUpdateTransition{Phase: phase, Time: t})
+}
+
+// newNodeUpdateInformer creates informer watching all NodeUpdate objects.
+func newNodeUpdateInformer(dc dynamic.Interface) cache.SharedIndexInformer {
+	return dynamicinformer.NewFilteredDynamicInformer(dc, nodeUpdateResource, metav1.NamespaceAll, 0,
+		cache.Indexers{}, nil).Informer()
+}
+
+// syncNodeUpdates creates or updates NodeUpdate object for every managed node, so the state of
+// the update of each node can be easily queried. NodeUpdate objects of nodes, which are no longer
+// managed, are removed.
+//
+// If there is an error updating any of NodeUpdate objects, an error is immediately returned.
+func (k *Kontroller) syncNodeUpdates(ctx context.Context, nodes []corev1.Node) error {
+	if !k.nodeUpdates {
+		return nil
+	}
+
+	managed := map[string]struct{}{}
+	now := time.Now()
+
+	for _, node := range managedNodes(nodes) {
+		managed[node.Name] = struct{}{}
+
+		nu, err := k.getNodeUpdate(node.Name)
+		if err != nil {
+			return fmt.Errorf("getting NodeUpdate for node %q: %w", node.Name, err)
+		}
+
+		if nu == nil {
+			if err := k.writeNodeUpdate(ctx, newNodeUpdate(node, now), true); err != nil {
+				return fmt.Errorf("creating NodeUpdate for node %q: %w", node.Name, err)
+			}
+
+			continue
+		}
+
+		status := nodeUpdateStatus(nu.Status, node, now)
+		if reflect.DeepEqual(status, nu.Status) {
+			continue
+		}
+
+		nu.Status = status
+
+		if err := k.writeNodeUpdate(ctx, nu, false); err != nil {
+			return fmt.Errorf("updating NodeUpdate for node %q: %w", node.Name, err)
+		}
+	}
+
+	for _, name := range k.nodeUpdateInformer.GetStore().ListKeys() {
+		if _, ok := managed[name]; ok {
+			continue
+		}
+
+		klog.Infof("Deleting NodeUpdate %q of node which is no longer managed", name)
+
+		err := k.dc.Resource(nodeUpdateResource).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deleting NodeUpdate %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// newNodeUpdate creates NodeUpdate for the given node. NodeUpdate is owned by the node, so it is
+// garbage collected when the node is removed.
+func newNodeUpdate(node corev1.Node, now time.Time) *updatev1alpha1.NodeUpdate {
+	return &updatev1alpha1.NodeUpdate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: node.Name,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: corev1.SchemeGroupVersion.String(),
+					Kind:       "Node",
+					Name:       node.Name,
+					UID:        node.UID,
+				},
+			},
+		},
+		Status: nodeUpdateStatus(updatev1alpha1.NodeUpdateStatus{}, node, now),
+	}
+}
+
+// getNodeUpdate gets NodeUpdate with the given name from the informer cache, including objects
+// written by the operator, which have not been delivered by the informer yet. If the object does
+// not exist, nil is returned.
+func (k *Kontroller) getNodeUpdate(name string) (*updatev1alpha1.NodeUpdate, error) {
+	obj, exists, err := k.nodeUpdateMutations.GetByKey(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting object from cache: %w", err)
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T in cache", obj)
+	}
+
+	nu := &updatev1alpha1.NodeUpdate{}
+
+	// Converted object does not share any data with the cached object, so it can be modified.
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), nu); err != nil {
+		return nil, fmt.Errorf("converting object: %w", err)
+	}
+
+	return nu, nil
+}
+
+// writeNodeUpdate creates or updates the given NodeUpdate and records the written object in the
+// mutation cache, so following reconciliations do not work with an outdated object.
+func (k *Kontroller) writeNodeUpdate(ctx context.Context, nu *updatev1alpha1.NodeUpdate, create bool) error {
+	nu.APIVersion = updatev1alpha1.SchemeGroupVersion.String()
+	nu.Kind = "NodeUpdate"
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(nu)
+	if err != nil {
+		return fmt.Errorf("converting object: %w", err)
+	}
+
+	obj := &unstructured.Unstructured{Object: content}
+	client := k.dc.Resource(nodeUpdateResource)
+
+	if create {
+		obj, err = client.Create(ctx, obj, metav1.CreateOptions{})
+	} else {
+		obj, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+	}
+
+	if err != nil {
+		return fmt.Errorf("writing object: %w", err)
+	}
+
+	k.nodeUpdateMutations.Mutation(obj)
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_NodePhase(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		labels      map[string]string
+		annotations map[string]string
+		expected    updatev1alpha1.NodeUpdatePhase
+	}{
+		"idle_when_reboot_is_not_needed": {
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.False,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseIdle,
+		},
+		"reboot_needed_when_waiting_for_reboot": {
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseRebootNeeded,
+		},
+		"paused_when_reboot_is_paused": {
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+				constants.AnnotationRebootPaused: constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhasePaused,
+		},
+		"before_reboot_when_labeled_for_before_reboot_checks": {
+			labels: map[string]string{
+				constants.LabelBeforeReboot: constants.True,
+			},
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseBeforeReboot,
+		},
+		"rebooting_when_allowed_to_reboot": {
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+				constants.AnnotationOkToReboot:   constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseRebooting,
+		},
+		"after_reboot_when_just_rebooted": {
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded:     constants.False,
+				constants.AnnotationRebootInProgress: constants.False,
+				constants.AnnotationOkToReboot:       constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseAfterReboot,
+		},
+		"after_reboot_when_labeled_for_after_reboot_checks": {
+			labels: map[string]string{
+				constants.LabelAfterReboot: constants.True,
+			},
+			annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.False,
+				constants.AnnotationOkToReboot:   constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseAfterReboot,
+		},
+		"failed_when_reboot_failed": {
+			labels: map[string]string{
+				constants.LabelAfterReboot: constants.True,
+			},
+			annotations: map[string]string{
+				constants.AnnotationOkToReboot:   constants.True,
+				constants.AnnotationRebootFailed: constants.True,
+			},
+			expected: updatev1alpha1.NodeUpdatePhaseFailed,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			node := corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      c.labels,
+					Annotations: c.annotations,
+				},
+			}
+
+			if phase := NodePhase(node); phase != c.expected {
+				t.Fatalf("Expected phase %q, got %q", c.expected, phase)
+			}
+		})
+	}
+}
+
+func Test_nodeUpdateStatus_records_phase_transitions_and_errors(t *testing.T) {
+	t.Parallel()
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				constants.LabelVersion: "2765.2.0",
+			},
+			Annotations: map[string]string{
+				constants.AnnotationRebootNeeded: constants.True,
+				constants.AnnotationNewVersion:   "2765.2.1",
+				constants.AnnotationStatus:       "UPDATE_STATUS_UPDATED_NEED_REBOOT",
+			},
+		},
+	}
+
+	start := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	status := nodeUpdateStatus(updatev1alpha1.NodeUpdateStatus{}, node, start)
+
+	if status.Phase != updatev1alpha1.NodeUpdatePhaseRebootNeeded {
+		t.Fatalf("Expected phase %q, got %q", updatev1alpha1.NodeUpdatePhaseRebootNeeded, status.Phase)
+	}
+
+	if status.CurrentVersion != "2765.2.0" || status.TargetVersion != "2765.2.1" {
+		t.Fatalf("Unexpected versions %q and %q", status.CurrentVersion, status.TargetVersion)
+	}
+
+	unchanged := nodeUpdateStatus(status, node, start.Add(time.Minute))
+	if !unchanged.LastTransitionTime.Equal(&status.LastTransitionTime) {
+		t.Fatalf("Expected transition time to be unchanged when phase is unchanged")
+	}
+
+	node.Annotations[constants.AnnotationOkToReboot] = constants.True
+	node.Annotations[constants.AnnotationDrainRefused] = "pods with local storage"
+
+	rebooting := nodeUpdateStatus(status, node, start.Add(time.Hour))
+
+	if rebooting.Phase != updatev1alpha1.NodeUpdatePhaseRebooting {
+		t.Fatalf("Expected phase %q, got %q", updatev1alpha1.NodeUpdatePhaseRebooting, rebooting.Phase)
+	}
+
+	if len(rebooting.Transitions) != 2 || !rebooting.Transitions[1].Time.Time.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Expected transition to rebooting phase to be recorded, got %+v", rebooting.Transitions)
+	}
+
+	if rebooting.LastError == "" || rebooting.LastErrorTime == nil {
+		t.Fatalf("Expected drain refusal to be recorded as last error")
+	}
+
+	if len(status.Transitions) != 1 {
+		t.Fatalf("Expected given status to not be modified, got %+v", status.Transitions)
+	}
+}
+
+func Test_syncNodeUpdates_creates_and_removes_NodeUpdates(t *testing.T) {
+	t.Parallel()
+
+	stale := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": updatev1alpha1.SchemeGroupVersion.String(),
+		"kind":       "NodeUpdate",
+		"metadata":   map[string]interface{}{"name": "removed"},
+	}}
+
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), stale)
+	informer := newNodeUpdateInformer(dc)
+
+	k := &Kontroller{
+		dc:                 dc,
+		nodeUpdates:        true,
+		nodeUpdateInformer: informer,
+		nodeUpdateMutations: cache.NewIntegerResourceVersionMutationCache(klog.Background(), informer.GetStore(),
+			informer.GetIndexer(), time.Minute, true),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go informer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		t.Fatalf("Waiting for informer to sync")
+	}
+
+	nodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "managed",
+				Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.True},
+			},
+		},
+	}
+
+	// Second sync runs before the informer delivers the created object, which must not be created again.
+	for i := 0; i < 2; i++ {
+		if err := k.syncNodeUpdates(ctx, nodes); err != nil {
+			t.Fatalf("Syncing NodeUpdates: %v", err)
+		}
+	}
+
+	list, err := dc.Resource(nodeUpdateResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Listing NodeUpdates: %v", err)
+	}
+
+	if len(list.Items) != 1 || list.Items[0].GetName() != "managed" {
+		t.Fatalf("Expected only NodeUpdate of managed node, got %v", list.Items)
+	}
+
+	phase, _, _ := unstructured.NestedString(list.Items[0].Object, "status", "phase")
+	if phase != string(updatev1alpha1.NodeUpdatePhaseRebootNeeded) {
+		t.Fatalf("Expected phase %q, got %q", updatev1alpha1.NodeUpdatePhaseRebootNeeded, phase)
+	}
+}
@@ -5,24 +5,30 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"reflect"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
-	"github.com/coreos/locksmith/pkg/timeutil"
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
 	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
 )
@@ -30,14 +36,29 @@ import (
 const (
 	eventSourceComponent               = "update-operator"
 	leaderElectionEventSourceComponent = "update-operator-leader-election"
-	maxRebootingNodes                  = 1
 
 	leaderElectionResourceName = "flatcar-linux-update-operator-lock"
 
-	// Arbitrarily copied from KVO.
-	leaderElectionLease = 90 * time.Second
+	// Lease values inspired by a combination of
+	// https://github.com/kubernetes/kubernetes/blob/f7c07a121d2afadde7aa15b12a9d02858b30a0a9/pkg/apis/componentconfig/v1alpha1/defaults.go#L163-L174
+	// and the KVO values. Renew deadline is 2/3rd of the lease duration to give controller enough
+	// time to renew the lease. Retry period is usually around 1/10th of lease duration, but given low
+	// dynamics of FLUO, 1/3rd should also be fine.
+	//
+	// See also
+	// https://github.com/kubernetes/kubernetes/blob/fc31dae165f406026142f0dd9a98cada8474682a/pkg/client/leaderelection/leaderelection.go#L17
+	defaultLeaderElectionLeaseDuration = 90 * time.Second
+	defaultLeaderElectionRenewDeadline = 60 * time.Second
+	defaultLeaderElectionRetryPeriod   = 30 * time.Second
+
+	// DefaultLeaderElectionResourceLock is a default type of the leader election lock. It holds both
+	// ConfigMap and Lease locks, so operators using only the ConfigMap lock are respected during upgrade.
+	DefaultLeaderElectionResourceLock = ConfigMapsLeasesResourceLock
 	// ReconciliationPeriod.
 	reconciliationPeriod = 30 * time.Second
+
+	// reconcileKey is the only key queued, as every reconciliation processes all nodes.
+	reconcileKey = "nodes"
 )
 
 var (
@@ -48,10 +69,19 @@ var (
 	// trigger a reboot, and the update-agent sets
 	// constants.AnnotationRebootNeeded and
 	// constants.AnnotationRebootInProgress to false when it has finished.
-	justRebootedSelector = fields.Set(map[string]string{
-		constants.AnnotationOkToReboot:       constants.True,
-		constants.AnnotationRebootNeeded:     constants.False,
-		constants.AnnotationRebootInProgress: constants.False,
+	//
+	// The update-agent sets the same annotations when it aborts the reboot, so
+	// nodes with constants.AnnotationRebootAborted set to true are excluded.
+	justRebootedSelector = fields.ParseSelectorOrDie(constants.AnnotationOkToReboot + "==" + constants.True +
+		"," + constants.AnnotationRebootNeeded + "==" + constants.False +
+		"," + constants.AnnotationRebootInProgress + "==" + constants.False +
+		"," + constants.AnnotationRebootAborted + "!=" + constants.True)
+
+	// rebootAbortedSelector is a selector for nodes, whose reboot has been aborted by the update-agent,
+	// but which still hold the permission to reboot.
+	rebootAbortedSelector = fields.Set(map[string]string{
+		constants.AnnotationOkToReboot:    constants.True,
+		constants.AnnotationRebootAborted: constants.True,
 	}).AsSelector()
 
 	// wantsRebootSelector is a selector for the annotation expected to be on a node when it wants to be rebooted.
@@ -88,6 +118,7 @@ var (
 // Kontroller implement operator part of FLUO.
 type Kontroller struct {
 	kc kubernetes.Interface
+	dc dynamic.Interface
 	nc corev1client.NodeInterface
 	er record.EventRecorder
 
@@ -97,6 +128,11 @@ type Kontroller struct {
 
 	leaderElectionClient        *kubernetes.Clientset
 	leaderElectionEventRecorder record.EventRecorder
+	leaderElectionNamespace     string
+	leaderElectionResourceLock  string
+	leaderElectionLeaseDuration time.Duration
+	leaderElectionRenewDeadline time.Duration
+	leaderElectionRetryPeriod   time.Duration
 	// Namespace is the kubernetes namespace any resources (e.g. locks,
 	// configmaps, agents) should be created and read under.
 	// It will be set to the namespace the operator is running in automatically.
@@ -105,22 +141,201 @@ type Kontroller struct {
 	// Auto-label Flatcar Container Linux nodes for migration compatibility.
 	autoLabelContainerLinux bool
 
-	// Reboot window.
-	rebootWindow *timeutil.Periodic
+	// Reboot windows. If empty, nodes may reboot at any time.
+	rebootWindows []*rebootWindow
+	// Reboot windows for pools of nodes selected by labels. Global reboot
+	// windows apply to nodes which are not selected by any pool.
+	poolRebootWindows []*poolRebootWindow
+
+	// Maximum number of managed nodes which may be unavailable at the same time,
+	// either as absolute number or as a percentage.
+	maxUnavailable intstr.IntOrString
+
+	// Node label key defining topology domains, e.g. 'topology.kubernetes.io/zone'
+	// and maximum number of nodes which may be rebooting in a single domain.
+	topologyKey               string
+	maxUnavailablePerTopology intstr.IntOrString
+
+	// Canary rollout configuration, nil if disabled.
+	canaries *canaries
+	// Canary nodes which failed at the last reconciliation, so the failure is only reported once.
+	failedCanaries map[string]struct{}
+
+	// Time in which node must come back ready after the agent started rebooting it.
+	rebootDeadline time.Duration
+
+	// Time nodes are not chosen for reboot after their reboot has been aborted and after update-agent
+	// refused to drain them.
+	rebootAbortBackoff  time.Duration
+	drainRefusedBackoff time.Duration
+
+	// Name of the ConfigMap in operator namespace with blackout periods.
+	blackoutConfigMap string
+	// Blackout state at the last check, so changes are only reported once.
+	blackoutState string
+
+	// Pods which are not evicted by the agent when draining nodes.
+	drainExclusions *k8sutil.DrainExclusions
+
+	// Order in which nodes are considered for rebooting.
+	nodeOrdering NodeOrdering
+
+	// Selector for control plane nodes, which are rebooted one at a time and never
+	// together with other nodes, optionally in dedicated reboot windows.
+	controlPlaneSelector      labels.Selector
+	controlPlaneRebootWindows []*rebootWindow
+
+	// Jobs run on nodes in before-reboot and after-reboot phases.
+	rebootJobs []*rebootJob
+
+	// Webhooks called for nodes in before-reboot and after-reboot phases.
+	rebootWebhooks []*rebootWebhook
+
+	// Maximum time nodes may spend in before-reboot and after-reboot phases.
+	beforeRebootTimeout time.Duration
+	afterRebootTimeout  time.Duration
+
+	// Maintain NodeUpdate object for every managed node.
+	nodeUpdates bool
+
+	// Only report decisions without updating nodes.
+	dryRun         bool
+	dryRunReporter *dryRunReporter
+
+	// Configuration given on creation, which is overridden by the UpdatePolicy.
+	config Config
+	// Name of the UpdatePolicy configuring the operator, empty if disabled.
+	updatePolicy string
+	// UID and generation of the last UpdatePolicy processed, so it is only applied when it changes.
+	updatePolicyUID        types.UID
+	updatePolicyGeneration int64
+	// Informer caching the UpdatePolicy, nil if disabled.
+	updatePolicyInformer cache.SharedIndexInformer
+	// Informer caching NodeUpdate objects, with objects written by the operator overlaid on top of it.
+	// Nil if disabled.
+	nodeUpdateInformer  cache.SharedIndexInformer
+	nodeUpdateMutations cache.MutationCache
+
+	// Informer caching nodes, with updates made by the operator overlaid on top of it.
+	nodeInformer  cache.SharedIndexInformer
+	nodeMutations cache.MutationCache
+	// Queue of pending reconciliations, filled by node events and periodically.
+	queue workqueue.Interface
+
+	// Metrics reported by the operator.
+	metrics *operatorMetrics
 }
 
 // Config configures a Kontroller.
 type Config struct {
 	// Kubernetes client.
 	Client kubernetes.Interface
+	// Kubernetes client for custom resources of the operator. Required if UpdatePolicy or NodeUpdates is set.
+	DynamicClient dynamic.Interface
 	// Migration compatibility.
 	AutoLabelContainerLinux bool
 	// Annotations to look for before and after reboots.
 	BeforeRebootAnnotations []string
 	AfterRebootAnnotations  []string
-	// Reboot window.
+	// Reboot window in the local time zone.
 	RebootWindowStart  string
 	RebootWindowLength string
+	// Additional reboot windows, each in the format '[<days>] <time of day> <length> <time zone>',
+	// e.g. 'Mon-Fri 22:00 4h Europe/Berlin'. Nodes may reboot when the current time falls inside
+	// any of the configured windows.
+	RebootWindows []string
+	// Reboot windows for pools of nodes selected by node labels. Windows of the first pool selecting
+	// the node apply to it. Reboot windows configured above apply to nodes not selected by any pool.
+	PoolRebootWindows []PoolRebootWindow
+	// Maximum number of nodes which may be rebooting or not ready at the same time.
+	// Either absolute number (e.g. '2') or a percentage of managed nodes (e.g. '10%').
+	// Defaults to 1.
+	MaxUnavailable string
+	// Node label key used to group nodes into topology domains, e.g. 'topology.kubernetes.io/zone'.
+	// If set, MaxUnavailablePerTopology limits number of rebooting nodes in each domain.
+	TopologyKey string
+	// Maximum number of nodes which may be rebooting at the same time within a single topology
+	// domain. Either absolute number or a percentage of managed nodes in the domain. Defaults to 1.
+	MaxUnavailablePerTopology string
+	// Canary nodes are rebooted before other nodes. They can be selected either using label
+	// selector or by count, in which case first nodes ordered by name are used.
+	CanarySelector string
+	CanaryCount    int
+	// Time canary nodes must run a new version before other nodes may reboot into it.
+	CanarySoakPeriod time.Duration
+	// Time in which node must come back ready after the agent started draining and rebooting it. If it
+	// does not, the node is marked as failed and all further reboots are halted until an administrator
+	// clears the failure. Zero disables the failure detection.
+	RebootDeadline time.Duration
+	// Time after the reboot of a node has been aborted, e.g. due to failed before-reboot checks, during
+	// which the node is not chosen for reboot again, so it is not repeatedly chosen and aborted. Zero
+	// disables the backoff.
+	RebootAbortBackoff time.Duration
+	// Time after update-agent refused to drain a node, during which the node is not chosen for reboot
+	// again, so it is not repeatedly drained and refused. Only used when longer than RebootAbortBackoff.
+	DrainRefusedBackoff time.Duration
+	// Name of the ConfigMap in the operator namespace containing blackout periods, during which
+	// no new reboots are started. The ConfigMap is read on every reconciliation.
+	BlackoutConfigMap string
+	// Namespaces and label selector of pods, which are not evicted when draining nodes. They should match
+	// the configuration of the update-agent, so only pods which are going to be evicted are checked
+	// against PodDisruptionBudgets, e.g. k8sutil.DefaultDrainExcludedNamespaces.
+	DrainExcludedNamespaces  []string
+	DrainExcludedPodSelector string
+	// Order in which nodes which want to reboot are considered for rebooting. If nil,
+	// nodes are considered in the order returned by the API server.
+	NodeOrdering NodeOrdering
+	// Label selector for control plane nodes. Defaults to DefaultControlPlaneSelector. Control plane
+	// nodes are rebooted one at a time, never together with any other node and only when no worker
+	// node is rebootable at the time.
+	ControlPlaneSelector string
+	// Reboot windows for control plane nodes, in the same format as RebootWindows. If configured,
+	// control plane nodes reboot only inside these windows, instead of the windows of their pool.
+	ControlPlaneRebootWindows []string
+	// Template of the Job, which is run on each node entering the before-reboot phase, pinned to the node.
+	// When the Job succeeds, the node is allowed to reboot. When it fails, the reboot is aborted.
+	BeforeRebootJobTemplate *batchv1.Job
+	// Template of the Job, which is run on each node entering the after-reboot phase, pinned to the node.
+	// When the Job succeeds, the reboot is completed. When it fails, all reboots are halted.
+	AfterRebootJobTemplate *batchv1.Job
+	// URL of the webhook, which is called for each node in the before-reboot phase. The webhook
+	// answers whether the node is allowed to reboot, denied or should be asked again later.
+	BeforeRebootWebhook string
+	// URL of the webhook, which is called for each node in the after-reboot phase. The webhook
+	// answers whether the node is allowed to complete the reboot, denied or should be asked again later.
+	AfterRebootWebhook string
+	// Timeout for webhook requests. Defaults to 10 seconds.
+	WebhookTimeout time.Duration
+	// Maximum time a node may spend in the before-reboot phase. If before-reboot annotations are not
+	// set to "true" in time, the reboot is aborted and the node is considered for reboot after other
+	// nodes. Zero means no timeout.
+	BeforeRebootTimeout time.Duration
+	// Maximum time a node may spend in the after-reboot phase. If after-reboot annotations are not
+	// set to "true" in time, the node is marked as failed, which halts all further reboots. Zero
+	// means no timeout.
+	AfterRebootTimeout time.Duration
+	// Name of the cluster-scoped UpdatePolicy object, which overrides the configuration above. The policy is
+	// read on every reconciliation and changes are applied without a restart. Empty value disables it.
+	UpdatePolicy string
+	// Maintain NodeUpdate object reporting the state of the update for every node managed by the
+	// update-agent. Requires the NodeUpdate custom resource definition to be installed.
+	NodeUpdates bool
+	// Type of the leader election lock, one of 'leases', 'configmapsleases' or 'configmaps'. Defaults
+	// to DefaultLeaderElectionResourceLock. To migrate to the Lease lock, first run all operator replicas
+	// with 'configmapsleases' and only then switch to 'leases'.
+	LeaderElectionResourceLock string
+	// Namespace of the leader election lock. Defaults to the operator namespace.
+	LeaderElectionNamespace string
+	// Duration non-leader candidates wait after observing a leadership renewal before they try to
+	// acquire the leadership. Defaults to 90 seconds.
+	LeaderElectionLeaseDuration time.Duration
+	// Duration the leader retries renewing the leadership before giving up. Defaults to 60 seconds.
+	LeaderElectionRenewDeadline time.Duration
+	// Duration candidates wait between attempts to acquire or renew the leadership. Defaults to 30 seconds.
+	LeaderElectionRetryPeriod time.Duration
+	// Evaluate the reconciliation without updating nodes, only logging and emitting Events describing
+	// the updates which would be made. Reboot Jobs are not created and node labels are not added.
+	DryRun bool
 }
 
 // New initializes a new Kontroller.
@@ -136,15 +351,27 @@ func New(config Config) (*Kontroller, error) {
 			"environment variable is set")
 	}
 
-	var rebootWindow *timeutil.Periodic
+	leaderElectionNamespace := config.LeaderElectionNamespace
+	if leaderElectionNamespace == "" {
+		leaderElectionNamespace = namespace
+	}
 
-	if config.RebootWindowStart != "" && config.RebootWindowLength != "" {
-		rw, err := timeutil.ParsePeriodic(config.RebootWindowStart, config.RebootWindowLength)
-		if err != nil {
-			return nil, fmt.Errorf("parsing reboot window: %w", err)
-		}
+	leaderElectionResourceLock := config.LeaderElectionResourceLock
+	if leaderElectionResourceLock == "" {
+		leaderElectionResourceLock = DefaultLeaderElectionResourceLock
+	}
+
+	if err := validateLeaderElectionResourceLock(leaderElectionResourceLock); err != nil {
+		return nil, err
+	}
 
-		rebootWindow = rw
+	leaseDuration, renewDeadline, retryPeriod, err := leaderElectionTimings(config)
+	if err != nil {
+		return nil, fmt.Errorf("configuring leader election: %w", err)
+	}
+
+	if (config.UpdatePolicy != "" || config.NodeUpdates) && config.DynamicClient == nil {
+		return nil, fmt.Errorf("dynamic client must not be nil when UpdatePolicy or NodeUpdates is set")
 	}
 
 	kc := config.Client
@@ -173,111 +400,634 @@ func New(config Config) (*Kontroller, error) {
 		Component: leaderElectionEventSourceComponent,
 	})
 
-	return &Kontroller{
+	nodeInformer := cache.NewSharedIndexInformer(
+		cache.NewListWatchFromClient(kc.CoreV1().RESTClient(), "nodes", corev1.NamespaceAll, fields.Everything()),
+		&corev1.Node{}, 0, cache.Indexers{},
+	)
+
+	k := &Kontroller{
 		kc:                          kc,
+		dc:                          config.DynamicClient,
 		nc:                          kc.CoreV1().Nodes(),
 		er:                          er,
-		beforeRebootAnnotations:     config.BeforeRebootAnnotations,
-		afterRebootAnnotations:      config.AfterRebootAnnotations,
 		leaderElectionClient:        leaderElectionClient,
 		leaderElectionEventRecorder: leaderElectionEventRecorder,
+		leaderElectionNamespace:     leaderElectionNamespace,
+		leaderElectionResourceLock:  leaderElectionResourceLock,
+		leaderElectionLeaseDuration: leaseDuration,
+		leaderElectionRenewDeadline: renewDeadline,
+		leaderElectionRetryPeriod:   retryPeriod,
 		namespace:                   namespace,
 		autoLabelContainerLinux:     config.AutoLabelContainerLinux,
-		rebootWindow:                rebootWindow,
-	}, nil
+		nodeUpdates:                 config.NodeUpdates,
+		dryRun:                      config.DryRun,
+		dryRunReporter:              newDryRunReporter(er),
+		config:                      config,
+		updatePolicy:                config.UpdatePolicy,
+		nodeInformer:                nodeInformer,
+		// Nodes updated by the operator are seen by the following reconciliations,
+		// even before the update is delivered by the informer.
+		nodeMutations: cache.NewIntegerResourceVersionMutationCache(klog.Background(), nodeInformer.GetStore(),
+			nodeInformer.GetIndexer(), reconciliationPeriod, false),
+		queue:   workqueue.NewNamed(eventSourceComponent),
+		metrics: newOperatorMetrics(config.DryRun),
+	}
+
+	nodeInformer.AddEventHandler(k.nodeEventHandler())
+
+	if config.UpdatePolicy != "" {
+		k.updatePolicyInformer = newUpdatePolicyInformer(config.DynamicClient, config.UpdatePolicy)
+		k.updatePolicyInformer.AddEventHandler(k.updatePolicyEventHandler())
+	}
+
+	if config.NodeUpdates {
+		k.nodeUpdateInformer = newNodeUpdateInformer(config.DynamicClient)
+		k.nodeUpdateMutations = cache.NewIntegerResourceVersionMutationCache(klog.Background(),
+			k.nodeUpdateInformer.GetStore(), k.nodeUpdateInformer.GetIndexer(), reconciliationPeriod, true)
+	}
+
+	if err := k.configure(config); err != nil {
+		return nil, err
+	}
+
+	return k, nil
 }
 
-// Run starts the operator reconcilitation process and runs until the stop
-// channel is closed.
-func (k *Kontroller) Run(stop <-chan struct{}) error {
-	err := k.withLeaderElection()
+// configure parses the given configuration and applies it to the Kontroller. If the configuration
+// is not valid, an error is returned and the Kontroller is left unchanged.
+func (k *Kontroller) configure(config Config) error {
+	rebootWindows := []*rebootWindow{}
+
+	if config.RebootWindowStart != "" && config.RebootWindowLength != "" {
+		rw, err := newLegacyRebootWindow(config.RebootWindowStart, config.RebootWindowLength)
+		if err != nil {
+			return fmt.Errorf("parsing reboot window: %w", err)
+		}
+
+		rebootWindows = append(rebootWindows, rw)
+	}
+
+	for _, spec := range config.RebootWindows {
+		rw, err := parseRebootWindow(spec)
+		if err != nil {
+			return fmt.Errorf("parsing reboot window %q: %w", spec, err)
+		}
+
+		rebootWindows = append(rebootWindows, rw)
+	}
+
+	poolRebootWindows := []*poolRebootWindow{}
+
+	for _, pool := range config.PoolRebootWindows {
+		prw, err := parsePoolRebootWindow(pool)
+		if err != nil {
+			return fmt.Errorf("parsing pool reboot windows: %w", err)
+		}
+
+		poolRebootWindows = append(poolRebootWindows, prw)
+	}
+
+	maxUnavailable, err := parseMaxUnavailable(config.MaxUnavailable)
+	if err != nil {
+		return fmt.Errorf("parsing max unavailable nodes: %w", err)
+	}
+
+	maxUnavailablePerTopology, err := parseMaxUnavailable(config.MaxUnavailablePerTopology)
+	if err != nil {
+		return fmt.Errorf("parsing max unavailable nodes per topology domain: %w", err)
+	}
+
+	canaries, err := newCanaries(config.CanarySelector, config.CanaryCount, config.CanarySoakPeriod)
+	if err != nil {
+		return fmt.Errorf("configuring canary rollout: %w", err)
+	}
+
+	controlPlaneSelector, err := parseControlPlaneSelector(config.ControlPlaneSelector)
+	if err != nil {
+		return fmt.Errorf("parsing control plane selector: %w", err)
+	}
+
+	controlPlaneRebootWindows := []*rebootWindow{}
+
+	for _, spec := range config.ControlPlaneRebootWindows {
+		rw, err := parseRebootWindow(spec)
+		if err != nil {
+			return fmt.Errorf("parsing control plane reboot window %q: %w", spec, err)
+		}
+
+		controlPlaneRebootWindows = append(controlPlaneRebootWindows, rw)
+	}
+
+	beforeRebootAnnotations := config.BeforeRebootAnnotations
+	afterRebootAnnotations := config.AfterRebootAnnotations
+	rebootJobs := []*rebootJob{}
+
+	if j := newRebootJob(rebootPhaseBefore, config.BeforeRebootJobTemplate); j != nil {
+		beforeRebootAnnotations = append(append([]string{}, beforeRebootAnnotations...), j.annotation)
+		rebootJobs = append(rebootJobs, j)
+	}
+
+	if j := newRebootJob(rebootPhaseAfter, config.AfterRebootJobTemplate); j != nil {
+		afterRebootAnnotations = append(append([]string{}, afterRebootAnnotations...), j.annotation)
+		rebootJobs = append(rebootJobs, j)
+	}
+
+	rebootWebhooks := []*rebootWebhook{}
+
+	beforeRebootWebhook, err := newRebootWebhook(rebootPhaseBefore, config.BeforeRebootWebhook, config.WebhookTimeout)
+	if err != nil {
+		return fmt.Errorf("configuring before-reboot webhook: %w", err)
+	}
+
+	if beforeRebootWebhook != nil {
+		beforeRebootAnnotations = append(append([]string{}, beforeRebootAnnotations...), beforeRebootWebhook.annotation)
+		rebootWebhooks = append(rebootWebhooks, beforeRebootWebhook)
+	}
+
+	afterRebootWebhook, err := newRebootWebhook(rebootPhaseAfter, config.AfterRebootWebhook, config.WebhookTimeout)
+	if err != nil {
+		return fmt.Errorf("configuring after-reboot webhook: %w", err)
+	}
+
+	if afterRebootWebhook != nil {
+		afterRebootAnnotations = append(append([]string{}, afterRebootAnnotations...), afterRebootWebhook.annotation)
+		rebootWebhooks = append(rebootWebhooks, afterRebootWebhook)
+	}
+
+	drainExclusions, err := k8sutil.NewDrainExclusions(config.DrainExcludedNamespaces, config.DrainExcludedPodSelector)
+	if err != nil {
+		return fmt.Errorf("parsing drain exclusions: %w", err)
+	}
+
+	nodeOrdering := config.NodeOrdering
+	if nodeOrdering == nil {
+		nodeOrdering = noOrdering{}
+	}
+
+	k.beforeRebootAnnotations = beforeRebootAnnotations
+	k.afterRebootAnnotations = afterRebootAnnotations
+	k.rebootWindows = rebootWindows
+	k.poolRebootWindows = poolRebootWindows
+	k.maxUnavailable = maxUnavailable
+	k.topologyKey = config.TopologyKey
+	k.maxUnavailablePerTopology = maxUnavailablePerTopology
+	k.canaries = canaries
+	k.rebootDeadline = config.RebootDeadline
+	k.rebootAbortBackoff = config.RebootAbortBackoff
+	k.drainRefusedBackoff = config.DrainRefusedBackoff
+	k.blackoutConfigMap = config.BlackoutConfigMap
+	k.drainExclusions = drainExclusions
+	k.nodeOrdering = nodeOrdering
+	k.controlPlaneSelector = controlPlaneSelector
+	k.controlPlaneRebootWindows = controlPlaneRebootWindows
+	k.rebootJobs = rebootJobs
+	k.rebootWebhooks = rebootWebhooks
+	k.beforeRebootTimeout = config.BeforeRebootTimeout
+	k.afterRebootTimeout = config.AfterRebootTimeout
+
+	return nil
+}
+
+// Run starts the operator reconcilitation process and runs until the given
+// context is cancelled or the leadership is lost. On cancellation, the
+// reconciliation is stopped before the leadership is released, so other
+// replicas can take over without waiting for the lease to expire.
+//
+// An error is returned if the leadership is lost or the reconciliation could
+// not be started.
+func (k *Kontroller) Run(ctx context.Context) error {
+	lock, err := k.leaderElectionLock()
 	if err != nil {
 		return err
 	}
 
+	// Leader election is cancelled only once the reconciliation stops, so the
+	// lease is not released while nodes may still be updated.
+	leaderElectionCtx, cancelLeaderElection := context.WithCancel(context.Background())
+	defer cancelLeaderElection()
+
+	var (
+		leading = make(chan struct{})
+		stopped = make(chan struct{})
+		runErr  error
+	)
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   k.leaderElectionLeaseDuration,
+		RenewDeadline:   k.leaderElectionRenewDeadline,
+		RetryPeriod:     k.leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				klog.V(5).Info("started leading")
+				close(leading)
+
+				defer close(stopped)
+				// Release the leadership when the reconciliation stops for any reason.
+				defer cancelLeaderElection()
+
+				runErr = k.runLeading(ctx, leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				klog.V(5).Info("stopped leading")
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating leader elector: %w", err)
+	}
+
+	// Stop waiting for the leadership on cancellation. Once leading, the
+	// reconciliation is stopped first.
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelLeaderElection()
+		case <-leading:
+		case <-leaderElectionCtx.Done():
+		}
+	}()
+
+	le.Run(leaderElectionCtx)
+
+	select {
+	case <-leading:
+		<-stopped
+	default:
+		// Cancelled before acquiring the leadership.
+		return nil
+	}
+
+	switch {
+	case runErr != nil:
+		return runErr
+	case ctx.Err() == nil:
+		return fmt.Errorf("leadership lost")
+	default:
+		return nil
+	}
+}
+
+// runLeading runs the reconciliation until the given context is cancelled or
+// the leadership is lost, which cancels the given leader context.
+func (k *Kontroller) runLeading(ctx, leaderCtx context.Context) error {
+	runCtx, cancel := context.WithCancel(leaderCtx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	return k.run(runCtx)
+}
+
+// run runs the reconciliation until the given context is cancelled.
+func (k *Kontroller) run(ctx context.Context) error {
+	go k.nodeInformer.Run(ctx.Done())
+
+	klog.V(5).Info("waiting for node informer to sync")
+
+	if !cache.WaitForCacheSync(ctx.Done(), k.nodeInformer.HasSynced) {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return fmt.Errorf("waiting for node informer to sync")
+	}
+
+	if k.updatePolicyInformer != nil {
+		go k.updatePolicyInformer.Run(ctx.Done())
+
+		klog.V(5).Info("waiting for UpdatePolicy informer to sync")
+
+		// Configuration from the policy is applied before the first reconciliation. Informer does not
+		// sync until the UpdatePolicy CustomResourceDefinition is installed.
+		if !cache.WaitForCacheSync(ctx.Done(), k.updatePolicyInformer.HasSynced) {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("waiting for UpdatePolicy informer to sync")
+		}
+	}
+
+	if k.nodeUpdateInformer != nil {
+		go k.nodeUpdateInformer.Run(ctx.Done())
+
+		klog.V(5).Info("waiting for NodeUpdate informer to sync")
+
+		// Informer does not sync until the NodeUpdate CustomResourceDefinition is installed.
+		if !cache.WaitForCacheSync(ctx.Done(), k.nodeUpdateInformer.HasSynced) {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("waiting for NodeUpdate informer to sync")
+		}
+	}
+
+	if k.dryRun {
+		klog.Warning("Running in dry run mode, nodes will not be updated")
+	}
+
 	// Start Flatcar Container Linux node auto-labeler.
 	if k.autoLabelContainerLinux {
-		go wait.Until(k.legacyLabeler, reconciliationPeriod, stop)
+		go wait.Until(k.legacyLabeler, reconciliationPeriod, ctx.Done())
 	}
 
 	klog.V(5).Info("starting controller")
 
-	// Call the process loop each period, until stop is closed.
-	wait.Until(k.process, reconciliationPeriod, stop)
+	// Reconcile each period even if nodes do not change, as reboot windows,
+	// blackout periods and timeouts depend on the current time.
+	go wait.Until(k.enqueue, reconciliationPeriod, ctx.Done())
+
+	// Reconcile whenever queued, until the context is cancelled.
+	workerStopped := make(chan struct{})
+
+	go func() {
+		defer close(workerStopped)
+
+		wait.UntilWithContext(ctx, k.runWorker, time.Second)
+	}()
+
+	<-ctx.Done()
 
 	klog.V(5).Info("stopping controller")
 
+	// Let the reconciliation in progress finish, so nodes are left in a consistent state.
+	k.queue.ShutDown()
+	<-workerStopped
+
+	klog.V(5).Info("controller stopped")
+
 	return nil
 }
 
-// withLeaderElection creates a new context which is cancelled when this
-// operator does not hold a lock to operate on the cluster.
-func (k *Kontroller) withLeaderElection() error {
-	// TODO: a better id might be necessary.
-	// Currently, KVO uses env.POD_NAME and the upstream controller-manager uses this.
-	// Both end up having the same value in general, but Hostname is
-	// more likely to have a value.
-	id, err := os.Hostname()
-	if err != nil {
-		return fmt.Errorf("getting hostname: %w", err)
+// enqueue queues the reconciliation. Multiple queued reconciliations are
+// collapsed into a single one.
+func (k *Kontroller) enqueue() {
+	k.queue.Add(reconcileKey)
+}
+
+// runWorker processes queued reconciliations until the queue is shut down.
+func (k *Kontroller) runWorker(ctx context.Context) {
+	for k.processNextItem(ctx) {
+	}
+}
+
+// processNextItem waits for the next queued reconciliation and performs it.
+// It returns false when the queue has been shut down.
+func (k *Kontroller) processNextItem(ctx context.Context) bool {
+	key, shutdown := k.queue.Get()
+	if shutdown {
+		return false
 	}
 
-	resLock := &resourcelock.ConfigMapLock{
-		ConfigMapMeta: metav1.ObjectMeta{
-			Namespace: k.namespace,
-			Name:      leaderElectionResourceName,
+	defer k.queue.Done(key)
+
+	k.process(ctx)
+
+	return true
+}
+
+// nodeEventHandler returns informer event handler queueing the reconciliation
+// on relevant node changes.
+func (k *Kontroller) nodeEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			k.enqueue()
 		},
-		Client: k.leaderElectionClient.CoreV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity:      id,
-			EventRecorder: k.leaderElectionEventRecorder,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, oldOK := oldObj.(*corev1.Node)
+			newNode, newOK := newObj.(*corev1.Node)
+
+			if !oldOK || !newOK || nodeChanged(oldNode, newNode) {
+				k.enqueue()
+			}
+		},
+		DeleteFunc: func(interface{}) {
+			k.enqueue()
 		},
 	}
+}
 
-	waitLeading := make(chan struct{})
-	go func(waitLeading chan<- struct{}) {
-		// Lease values inspired by a combination of
-		// https://github.com/kubernetes/kubernetes/blob/f7c07a121d2afadde7aa15b12a9d02858b30a0a9/pkg/apis/componentconfig/v1alpha1/defaults.go#L163-L174
-		// and the KVO values
-		// See also
-		// https://github.com/kubernetes/kubernetes/blob/fc31dae165f406026142f0dd9a98cada8474682a/pkg/client/leaderelection/leaderelection.go#L17
-		leaderelection.RunOrDie(context.TODO(), leaderelection.LeaderElectionConfig{
-			Lock:          resLock,
-			LeaseDuration: leaderElectionLease,
-			//nolint:gomnd // Set renew deadline to 2/3rd of the lease duration to give
-			//             // controller enough time to renew the lease.
-			RenewDeadline: leaderElectionLease * 2 / 3,
-			//nolint:gomnd // Retry duration is usually around 1/10th of lease duration,
-			//             // but given low dynamics of FLUO, 1/3rd should also be fine.
-			RetryPeriod: leaderElectionLease / 3,
-			Callbacks: leaderelection.LeaderCallbacks{
-				OnStartedLeading: func(ctx context.Context) { // was: func(stop <-chan struct{
-					klog.V(5).Info("started leading")
-					waitLeading <- struct{}{}
-				},
-				OnStoppedLeading: func() {
-					klog.Fatalf("leaderelection lost")
-				},
-			},
-		})
-	}(waitLeading)
+// nodeChanged returns true if the node has changed in a way relevant for the
+// reconciliation. Periodic node status heartbeats are ignored.
+func nodeChanged(oldNode, newNode *corev1.Node) bool {
+	return !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+		!reflect.DeepEqual(oldNode.Annotations, newNode.Annotations) ||
+		oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable ||
+		isNodeReady(*oldNode) != isNodeReady(*newNode)
+}
 
-	<-waitLeading
+// leaderElectionLock creates the lock used for the leader election between operator replicas.
+func (k *Kontroller) leaderElectionLock() (resourcelock.Interface, error) {
+	// Pod name is unique among operator replicas, unlike the hostname with host networking.
+	id := os.Getenv("POD_NAME")
+	if id == "" {
+		klog.Warning("POD_NAME environment variable is not set, using hostname as leader election identity")
 
-	return nil
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("getting hostname: %w", err)
+		}
+
+		id = hostname
+	}
+
+	config := resourcelock.ResourceLockConfig{
+		Identity:      id,
+		EventRecorder: k.leaderElectionEventRecorder,
+	}
+
+	cmLock := &configMapLock{
+		meta: metav1.ObjectMeta{
+			Namespace: k.leaderElectionNamespace,
+			Name:      leaderElectionResourceName,
+		},
+		client: k.leaderElectionClient.CoreV1(),
+		config: config,
+	}
+
+	if k.leaderElectionResourceLock == ConfigMapsResourceLock {
+		return cmLock, nil
+	}
+
+	leaseLock, err := resourcelock.New(resourcelock.LeasesResourceLock, k.leaderElectionNamespace,
+		leaderElectionResourceName, k.leaderElectionClient.CoreV1(), k.leaderElectionClient.CoordinationV1(), config)
+	if err != nil {
+		return nil, fmt.Errorf("creating leader election lock: %w", err)
+	}
+
+	if k.leaderElectionResourceLock == ConfigMapsLeasesResourceLock {
+		return &resourcelock.MultiLock{
+			Primary:   cmLock,
+			Secondary: leaseLock,
+		}, nil
+	}
+
+	return leaseLock, nil
 }
 
-// process performs the reconcilitation to coordinate reboots.
-func (k *Kontroller) process() {
+// validateLeaderElectionResourceLock returns an error if given leader election lock type is not supported.
+func validateLeaderElectionResourceLock(lockType string) error {
+	switch lockType {
+	case resourcelock.LeasesResourceLock, ConfigMapsLeasesResourceLock, ConfigMapsResourceLock:
+		return nil
+	default:
+		return fmt.Errorf("unknown leader election lock type %q, supported values are: %q, %q, %q", lockType,
+			resourcelock.LeasesResourceLock, ConfigMapsLeasesResourceLock, ConfigMapsResourceLock)
+	}
+}
+
+// leaderElectionTimings returns leader election lease duration, renew deadline and retry period from
+// the given configuration with defaults applied. An error is returned if they are not consistent.
+func leaderElectionTimings(config Config) (leaseDuration, renewDeadline, retryPeriod time.Duration, err error) {
+	leaseDuration = config.LeaderElectionLeaseDuration
+	if leaseDuration == 0 {
+		leaseDuration = defaultLeaderElectionLeaseDuration
+	}
+
+	renewDeadline = config.LeaderElectionRenewDeadline
+	if renewDeadline == 0 {
+		renewDeadline = defaultLeaderElectionRenewDeadline
+	}
+
+	retryPeriod = config.LeaderElectionRetryPeriod
+	if retryPeriod == 0 {
+		retryPeriod = defaultLeaderElectionRetryPeriod
+	}
+
+	switch {
+	case leaseDuration < 0 || renewDeadline < 0 || retryPeriod < 0:
+		return 0, 0, 0, fmt.Errorf("lease duration, renew deadline and retry period must be positive")
+	case leaseDuration <= renewDeadline:
+		return 0, 0, 0, fmt.Errorf("lease duration %v must be greater than renew deadline %v", leaseDuration,
+			renewDeadline)
+	case float64(renewDeadline) <= leaderelection.JitterFactor*float64(retryPeriod):
+		return 0, 0, 0, fmt.Errorf("renew deadline %v must be greater than retry period %v multiplied by %v",
+			renewDeadline, retryPeriod, leaderelection.JitterFactor)
+	}
+
+	return leaseDuration, renewDeadline, retryPeriod, nil
+}
+
+// process performs the reconcilitation to coordinate reboots. Calls to external
+// services, like webhooks, are cancelled when the given context is cancelled.
+func (k *Kontroller) process(ctx context.Context) {
 	klog.V(4).Info("Going through a loop cycle")
 
+	// Apply changes to the UpdatePolicy. On failure, continue with the
+	// previously applied configuration.
+	klog.V(4).Info("Syncing update policy")
+
+	if err := k.syncUpdatePolicy(ctx); err != nil {
+		klog.Errorf("Failed to sync update policy: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("update-policy").Inc()
+	}
+
+	// Take a snapshot of all nodes from the informer cache, which all steps
+	// below work with.
+	nodes, err := newNodeSnapshot(k.nc, k.nodeInformer.GetStore(), k.nodeMutations)
+	if err != nil {
+		klog.Errorf("Failed to get nodes: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("nodes").Inc()
+
+		return
+	}
+
+	// In dry run, updates of nodes are only reported.
+	if k.dryRun {
+		k.dryRunReporter.start()
+		nodes.setDryRun(k.dryRunReporter)
+	}
+
+	// Report the state of nodes in NodeUpdate objects and metrics at the end
+	// of each cycle, even if any of the steps below fails.
+	defer func() {
+		now := time.Now()
+
+		k.metrics.observeNodes(nodes.observed, func(node corev1.Node) bool {
+			return insideRebootWindows(k.rebootWindowsFor(node), now)
+		})
+
+		klog.V(4).Info("Syncing node updates")
+
+		if err := k.syncNodeUpdates(ctx, nodes.observed); err != nil {
+			klog.Errorf("Failed to sync node updates: %v", err)
+			k.metrics.reconciliationErrors.WithLabelValues("node-updates").Inc()
+		}
+	}()
+
 	// First make sure that all of our nodes are in a well-defined state with
 	// respect to our annotations and labels, and if they are not, then try to
 	// fix them.
 	klog.V(4).Info("Cleaning up node state")
 
-	if err := k.cleanupState(); err != nil {
+	if err := k.cleanupState(nodes); err != nil {
 		klog.Errorf("Failed to cleanup node state: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("cleanup-state").Inc()
+
+		return
+	}
+
+	// Find nodes whose reboot has been aborted by the agent and release their
+	// reboot slot, so they are not mistaken for rebooted nodes.
+	klog.V(4).Info("Releasing reboots aborted by agents")
+
+	if err := k.releaseAbortedReboots(nodes); err != nil {
+		klog.Errorf("Failed to release aborted reboots: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("release-aborted-reboots").Inc()
+
+		return
+	}
+
+	// Find nodes which did not come back from the reboot in time and mark
+	// them as failed, which halts all further reboots.
+	klog.V(4).Info("Detecting failed reboots")
+
+	if err := k.detectRebootFailures(nodes); err != nil {
+		klog.Errorf("Failed to detect failed reboots: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("detect-reboot-failures").Inc()
+
+		return
+	}
+
+	// Run configured before-reboot and after-reboot Jobs on nodes in these
+	// phases and set the matching annotations for Jobs which succeeded.
+	klog.V(4).Info("Running before-reboot and after-reboot jobs")
+
+	if err := k.runRebootJobs(nodes); err != nil {
+		klog.Errorf("Failed to run reboot jobs: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("reboot-jobs").Inc()
+
+		return
+	}
+
+	// Call configured before-reboot and after-reboot webhooks for nodes in these
+	// phases and set the matching annotations based on their answers.
+	klog.V(4).Info("Calling before-reboot and after-reboot webhooks")
+
+	if err := k.runRebootWebhooks(ctx, nodes); err != nil {
+		klog.Errorf("Failed to call reboot webhooks: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("reboot-webhooks").Inc()
+
+		return
+	}
+
+	// Find nodes with the before-reboot=true or after-reboot=true label, which
+	// have any of the configured annotations set to a failure value or which
+	// did not get all the annotations set in time, and abort their reboot.
+	klog.V(4).Info("Aborting reboots of nodes which failed before-reboot or after-reboot checks")
+
+	if err := k.abortFailedPhases(nodes); err != nil {
+		klog.Errorf("Failed to abort failed reboots: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("abort-failed-phases").Inc()
 
 		return
 	}
@@ -288,8 +1038,9 @@ func (k *Kontroller) process() {
 	// the reboot has completed.
 	klog.V(4).Info("Checking if configured after-reboot annotations are set to true")
 
-	if err := k.checkAfterReboot(); err != nil {
+	if err := k.checkAfterReboot(nodes); err != nil {
 		klog.Errorf("Failed to check after reboot: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("check-after-reboot").Inc()
 
 		return
 	}
@@ -298,8 +1049,9 @@ func (k *Kontroller) process() {
 	// remove after-reboot annotations and add the after-reboot=true label.
 	klog.V(4).Info("Labeling rebooted nodes with after-reboot label")
 
-	if err := k.markAfterReboot(); err != nil {
+	if err := k.markAfterReboot(nodes); err != nil {
 		klog.Errorf("Failed to update recently rebooted nodes: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("mark-after-reboot").Inc()
 
 		return
 	}
@@ -310,8 +1062,9 @@ func (k *Kontroller) process() {
 	// time to reboot.
 	klog.V(4).Info("Checking if configured before-reboot annotations are set to true")
 
-	if err := k.checkBeforeReboot(); err != nil {
+	if err := k.checkBeforeReboot(nodes); err != nil {
 		klog.Errorf("Failed to check before reboot: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("check-before-reboot").Inc()
 
 		return
 	}
@@ -320,8 +1073,9 @@ func (k *Kontroller) process() {
 	// annotations and add the before-reboot=true label.
 	klog.V(4).Info("Labeling rebootable nodes with before-reboot label")
 
-	if err := k.markBeforeReboot(); err != nil {
+	if err := k.markBeforeReboot(nodes); err != nil {
 		klog.Errorf("Failed to update rebootable nodes: %v", err)
+		k.metrics.reconciliationErrors.WithLabelValues("mark-before-reboot").Inc()
 
 		return
 	}
@@ -329,27 +1083,25 @@ func (k *Kontroller) process() {
 
 // cleanupState attempts to make sure nodes are in a well-defined state before
 // performing state changes on them.
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) cleanupState() error {
-	nodelist, err := k.nc.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("listing nodes: %w", err)
-	}
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) cleanupState(nodes *nodeSnapshot) error {
+	for _, n := range nodes.items {
+		// Only nodes which are not in a well-defined state are updated.
+		if !noLongerWantsReboot(n) {
+			continue
+		}
 
-	for _, n := range nodelist.Items {
-		err = k8sutil.UpdateNodeRetry(k.nc, n.Name, func(node *corev1.Node) {
+		err := nodes.update(n.Name, func(node *corev1.Node) {
 			// Make sure that nodes with the before-reboot label actually
 			// still wants to reboot.
-			if _, exists := node.Labels[constants.LabelBeforeReboot]; exists {
-				if !wantsRebootSelector.Matches(fields.Set(node.Annotations)) {
-					klog.Warningf("Node %v no longer wanted to reboot while we were trying to label it so: %v",
-						node.Name, node.Annotations)
-					delete(node.Labels, constants.LabelBeforeReboot)
-					for _, annotation := range k.beforeRebootAnnotations {
-						delete(node.Annotations, annotation)
-					}
+			if noLongerWantsReboot(*node) {
+				klog.Warningf("Node %v no longer wanted to reboot while we were trying to label it so: %v",
+					node.Name, node.Annotations)
+				delete(node.Labels, constants.LabelBeforeReboot)
+				for _, annotation := range k.beforeRebootAnnotations {
+					delete(node.Annotations, annotation)
 				}
+				delete(node.Annotations, constants.AnnotationBeforeRebootTime)
 			}
 		})
 		if err != nil {
@@ -360,6 +1112,16 @@ func (k *Kontroller) cleanupState() error {
 	return nil
 }
 
+// noLongerWantsReboot returns true if the node has the before-reboot label, but it does not want
+// to reboot anymore.
+func noLongerWantsReboot(node corev1.Node) bool {
+	if _, exists := node.Labels[constants.LabelBeforeReboot]; !exists {
+		return false
+	}
+
+	return !wantsRebootSelector.Matches(fields.Set(node.Annotations))
+}
+
 // checkReboot gets all nodes with a given requirement and checks if all of the given annotations are set to true.
 //
 // If they are, it deletes given annotations and label, then sets ok-to-reboot annotation to either true or false,
@@ -369,17 +1131,12 @@ func (k *Kontroller) cleanupState() error {
 //
 // If ok-to-reboot is set to false, it means node has finished rebooting successfully.
 //
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) checkReboot(req *labels.Requirement, annotations []string, label, okToReboot string) error {
-	nodelist, err := k.nc.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("listing nodes: %w", err)
-	}
-
-	nodes := k8sutil.FilterNodesByRequirement(nodelist.Items, req)
-
-	for _, n := range nodes {
+// Time spent by the node in the given phase is recorded in metrics.
+//
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) checkReboot(nodes *nodeSnapshot, phase string, req *labels.Requirement, annotations []string,
+	label, okToReboot string) error {
+	for _, n := range k8sutil.FilterNodesByRequirement(nodes.items, req) {
 		if !hasAllAnnotations(n, annotations) {
 			continue
 		}
@@ -387,7 +1144,7 @@ func (k *Kontroller) checkReboot(req *labels.Requirement, annotations []string,
 		klog.V(4).Infof("Deleting label %q for %q", label, n.Name)
 		klog.V(4).Infof("Setting annotation %q to %q for %q", constants.AnnotationOkToReboot, okToReboot, n.Name)
 
-		if err := k8sutil.UpdateNodeRetry(k.nc, n.Name, func(node *corev1.Node) {
+		if err := nodes.update(n.Name, func(node *corev1.Node) {
 			delete(node.Labels, label)
 
 			// Cleanup the annotations.
@@ -397,9 +1154,24 @@ func (k *Kontroller) checkReboot(req *labels.Requirement, annotations []string,
 			}
 
 			node.Annotations[constants.AnnotationOkToReboot] = okToReboot
+
+			if okToReboot == constants.True {
+				node.Annotations[constants.AnnotationOkToRebootTime] = time.Now().UTC().Format(time.RFC3339)
+				delete(node.Annotations, constants.AnnotationRebootFailed)
+				delete(node.Annotations, constants.AnnotationRebootAbortedTime)
+				delete(node.Annotations, constants.AnnotationBeforeRebootTime)
+				delete(node.Annotations, constants.AnnotationDrainRefused)
+			}
+
+			if okToReboot == constants.False {
+				node.Annotations[constants.AnnotationRebootCompletedTime] = time.Now().UTC().Format(time.RFC3339)
+				delete(node.Annotations, constants.AnnotationAfterRebootTime)
+			}
 		}); err != nil {
 			return fmt.Errorf("updating node %q: %w", n.Name, err)
 		}
+
+		k.metrics.observePhaseCompleted(phase, n, time.Now())
 	}
 
 	return nil
@@ -411,116 +1183,222 @@ func (k *Kontroller) checkReboot(req *labels.Requirement, annotations []string,
 // the agent that it is ready to start the actual reboot process.
 // If it goes to set reboot-ok=true and finds that the node no longer wants a
 // reboot, then it just deletes the before-reboot=true label.
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) checkBeforeReboot() error {
-	return k.checkReboot(beforeRebootReq, k.beforeRebootAnnotations, constants.LabelBeforeReboot, constants.True)
+// If reboots are halted due to failed reboot of some node or if we are inside
+// a blackout period, no node is allowed to reboot.
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) checkBeforeReboot(nodes *nodeSnapshot) error {
+	if rebootsHalted(nodes.items) {
+		klog.Info("Reboots are halted; not allowing nodes to reboot")
+
+		return nil
+	}
+
+	blackout, err := k.insideBlackout(time.Now())
+	if err != nil {
+		return fmt.Errorf("checking blackout periods: %w", err)
+	}
+
+	if blackout {
+		klog.V(4).Info("Inside blackout period; not allowing nodes to reboot")
+
+		return nil
+	}
+
+	return k.checkReboot(nodes, rebootPhaseBefore, beforeRebootReq, k.beforeRebootAnnotations,
+		constants.LabelBeforeReboot, constants.True)
 }
 
 // checkAfterReboot gets all nodes with the after-reboot=true label and checks
 // if  all of the configured after-reboot annotations are set to true. If they
 // are, it deletes the after-reboot=true label and sets reboot-ok=false to tell
 // the agent that it has completed it's reboot successfully.
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) checkAfterReboot() error {
-	return k.checkReboot(afterRebootReq, k.beforeRebootAnnotations, constants.LabelAfterReboot, constants.False)
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) checkAfterReboot(nodes *nodeSnapshot) error {
+	return k.checkReboot(nodes, rebootPhaseAfter, afterRebootReq, k.afterRebootAnnotations,
+		constants.LabelAfterReboot, constants.False)
 }
 
 // markBeforeReboot gets nodes which want to reboot and marks them with the
 // before-reboot=true label. This is considered the beginning of the reboot
 // process from the perspective of the update-operator. It will only mark
-// nodes with this label up to the maximum number of concurrently rebootable
-// nodes as configured with the maxRebootingNodes constant. It also checks if
-// we are inside the reboot window.
+// nodes with this label up to the maximum number of concurrently unavailable
+// nodes as configured with the maxUnavailable field. Managed nodes which are
+// not ready for unrelated reasons count against this limit as well. If topology
+// key is configured, nodes from topology domains which already have maximum
+// number of rebooting nodes are skipped. It also checks if each node is inside
+// any of the reboot windows of its node pool. If canary rollout is configured, only canary nodes are
+// marked until they run the new version for the configured soak period.
+// If reboots are halted due to failed reboot of some node or if we are inside
+// a blackout period, no node is marked. Nodes are considered in the order
+// given by the configured node ordering, with nodes whose reboot has been
+// aborted considered last, once their backoff expires. Control plane nodes are marked one at
+// a time and only when no other node is rebooting or has been marked.
 // It cleans up the before-reboot annotations before it applies the label, in
 // case there are any left over from the last reboot.
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) markBeforeReboot() error {
-	nodelist, err := k.nc.List(context.TODO(), metav1.ListOptions{})
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) markBeforeReboot(nodes *nodeSnapshot) error {
+	if rebootsHalted(nodes.items) {
+		klog.Info("Reboots are halted; not labeling rebootable nodes")
+
+		return nil
+	}
+
+	blackout, err := k.insideBlackout(time.Now())
 	if err != nil {
-		return fmt.Errorf("listing nodes: %w", err)
+		return fmt.Errorf("checking blackout periods: %w", err)
 	}
 
-	// Check if a reboot window is configured.
-	if k.rebootWindow != nil {
-		// Get previous occurrence relative to now.
-		period := k.rebootWindow.Previous(time.Now())
-		// Check if we are inside the reboot window.
-		if !(period.End.After(time.Now())) {
-			klog.V(4).Info("We are outside the reboot window; not labeling rebootable nodes for now")
+	if blackout {
+		klog.V(4).Info("Inside blackout period; not labeling rebootable nodes")
 
-			return nil
-		}
+		return nil
 	}
 
 	// Find nodes which are still rebooting.
-	rebootingNodes := k8sutil.FilterNodesByAnnotation(nodelist.Items, stillRebootingSelector)
+	rebootingNodes := k8sutil.FilterNodesByAnnotation(nodes.items, stillRebootingSelector)
 	// Nodes running before and after reboot checks are still considered to be "rebooting" to us.
-	beforeRebootNodes := k8sutil.FilterNodesByRequirement(nodelist.Items, beforeRebootReq)
+	beforeRebootNodes := k8sutil.FilterNodesByRequirement(nodes.items, beforeRebootReq)
 	rebootingNodes = append(rebootingNodes, beforeRebootNodes...)
-	afterRebootNodes := k8sutil.FilterNodesByRequirement(nodelist.Items, afterRebootReq)
+	afterRebootNodes := k8sutil.FilterNodesByRequirement(nodes.items, afterRebootReq)
 	rebootingNodes = append(rebootingNodes, afterRebootNodes...)
 
-	// Verify the number of currently rebooting nodes is less than the the maximum number.
-	if len(rebootingNodes) >= maxRebootingNodes {
-		for _, n := range rebootingNodes {
-			klog.Infof("Found node %q still rebooting, waiting", n.Name)
+	// Managed nodes which are not ready are unavailable as well, so they count against the limit.
+	managed := managedNodes(nodes.items)
+	unavailable := unavailableNodes(rebootingNodes, managed)
+	maxUnavailable := maxUnavailableNodes(k.maxUnavailable, len(managed))
+
+	// Verify the number of currently unavailable nodes is less than the the maximum number.
+	if len(unavailable) >= maxUnavailable {
+		for _, n := range unavailable {
+			klog.Infof("Found node %q still rebooting or not ready, waiting", n.Name)
 		}
 
-		klog.Infof("Found %d (of max %d) rebooting nodes; waiting for completion", len(rebootingNodes), maxRebootingNodes)
+		klog.Infof("Found %d (of max %d) unavailable nodes; waiting for completion", len(unavailable), maxUnavailable)
+
+		return nil
+	}
+
+	// Control plane nodes are never rebooted together with any other node.
+	if _, rebootingControlPlane := k.splitControlPlaneNodes(rebootingNodes); len(rebootingControlPlane) > 0 {
+		klog.Infof("Found control plane node %q still rebooting, waiting", rebootingControlPlane[0].Name)
 
 		return nil
 	}
 
 	// Find nodes which want to reboot.
-	rebootableNodes := k8sutil.FilterNodesByAnnotation(nodelist.Items, wantsRebootSelector)
+	rebootableNodes := k8sutil.FilterNodesByAnnotation(nodes.items, wantsRebootSelector)
 	rebootableNodes = k8sutil.FilterNodesByRequirement(rebootableNodes, notBeforeRebootReq)
+	// Each node may only reboot inside the reboot windows of its pool.
+	rebootableNodes = k.filterNodesInsideRebootWindows(rebootableNodes, time.Now())
+	// Nodes whose reboot has been aborted are not chosen again right away.
+	rebootableNodes = k.filterBackedOffNodes(rebootableNodes, time.Now())
+	// With canary rollout, other nodes must wait until canary nodes successfully run the new version.
+	rebootableNodes = k.filterCanaryRebootableNodes(managed, rebootableNodes)
 
 	// Don't even bother if rebootableNodes is empty. We wouldn't do anything anyway.
 	if len(rebootableNodes) == 0 {
 		return nil
 	}
 
-	// Find the number of nodes we can tell to reboot.
-	remainingRebootableCount := maxRebootingNodes - len(rebootingNodes)
+	// Consider nodes in the configured order.
+	rebootableNodes, err = k.nodeOrdering.Order(rebootableNodes)
+	if err != nil {
+		return fmt.Errorf("ordering rebootable nodes: %w", err)
+	}
+
+	// Nodes whose reboot has been aborted go to the back of the queue.
+	rebootableNodes = abortedLast(rebootableNodes)
+
+	rebootableWorkers, rebootableControlPlane := k.splitControlPlaneNodes(rebootableNodes)
 
-	// Choose some number of nodes.
-	chosenNodes := make([]*corev1.Node, 0, remainingRebootableCount)
-	for i := 0; i < remainingRebootableCount && i < len(rebootableNodes); i++ {
-		chosenNodes = append(chosenNodes, &rebootableNodes[i])
+	// Each topology domain has its own limit of rebooting nodes.
+	topology := newTopologyBudget(k.topologyKey, k.maxUnavailablePerTopology, managed, rebootingNodes)
+
+	// Draining chosen nodes must not violate any PodDisruptionBudget, including pods which are
+	// going to be evicted from nodes already allowed to reboot.
+	pendingDrain := k8sutil.FilterNodesByAnnotation(nodes.items, stillRebootingSelector)
+	pendingDrain = append(pendingDrain, beforeRebootNodes...)
+
+	pdbs, err := newPDBBudget(k.kc, k.drainExclusions, pendingDrain)
+	if err != nil {
+		return fmt.Errorf("getting PodDisruptionBudgets: %w", err)
+	}
+
+	// Choose some number of worker nodes.
+	chosenNodes, err := chooseNodes(rebootableWorkers, maxUnavailable-len(unavailable), topology, pdbs)
+	if err != nil {
+		return fmt.Errorf("choosing worker nodes: %w", err)
+	}
+
+	// Choose a single control plane node, if there is nothing else to do.
+	if candidates := k.controlPlaneCandidates(chosenNodes, rebootingNodes, rebootableControlPlane); len(candidates) > 0 {
+		chosenNodes, err = chooseNodes(candidates, 1, topology, pdbs)
+		if err != nil {
+			return fmt.Errorf("choosing control plane node: %w", err)
+		}
 	}
 
 	// Set before-reboot=true for the chosen nodes.
 	klog.Infof("Found %d nodes that need a reboot", len(chosenNodes))
 
 	for _, n := range chosenNodes {
-		err = k.mark(n.Name, constants.LabelBeforeReboot, "before-reboot", k.beforeRebootAnnotations)
+		err = k.mark(nodes, n.Name, constants.LabelBeforeReboot, constants.AnnotationBeforeRebootTime, "before-reboot",
+			k.beforeRebootAnnotations)
 		if err != nil {
 			return fmt.Errorf("labeling node for before reboot checks: %w", err)
 		}
+
+		k.metrics.observePhaseCompleted(metricsPhaseWantsReboot, *n, time.Now())
 	}
 
 	return nil
 }
 
+// chooseNodes chooses up to count nodes from the given rebootable nodes, skipping nodes from topology
+// domains which have no reboot slots left and nodes whose drain would violate any PodDisruptionBudget.
+func chooseNodes(rebootableNodes []corev1.Node, count int, topology *topologyBudget,
+	pdbs *pdbBudget) ([]*corev1.Node, error) {
+	chosenNodes := make([]*corev1.Node, 0, count)
+
+	for i := 0; len(chosenNodes) < count && i < len(rebootableNodes); i++ {
+		node := &rebootableNodes[i]
+
+		disruptions, err := pdbs.disruptions(*node)
+		if err != nil {
+			return nil, fmt.Errorf("checking PodDisruptionBudgets for node %q: %w", node.Name, err)
+		}
+
+		if pdb := pdbs.violated(disruptions); pdb != "" {
+			klog.Infof("Skipping node %q, draining it would violate PodDisruptionBudget %q", node.Name, pdb)
+
+			continue
+		}
+
+		if !topology.take(*node) {
+			klog.V(4).Infof("Skipping node %q, no reboot slots left in topology domain %q", node.Name,
+				topology.domain(*node))
+
+			continue
+		}
+
+		pdbs.take(disruptions)
+
+		chosenNodes = append(chosenNodes, node)
+	}
+
+	return chosenNodes, nil
+}
+
 // markAfterReboot gets nodes which have completed rebooting and marks them with
 // the after-reboot=true label. A node with the after-reboot=true label is still
 // considered to be rebooting from the perspective of the update-operator, even
 // though it has completed rebooting from the machines perspective.
 // It cleans up the after-reboot annotations before it applies the label, in
 // case there are any left over from the last reboot.
-// If there is an error getting the list of nodes or updating any of them, an
-// error is immediately returned.
-func (k *Kontroller) markAfterReboot() error {
-	nodelist, err := k.nc.List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("listing nodes: %w", err)
-	}
-
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) markAfterReboot(nodes *nodeSnapshot) error {
 	// Find nodes which just rebooted.
-	justRebootedNodes := k8sutil.FilterNodesByAnnotation(nodelist.Items, justRebootedSelector)
+	justRebootedNodes := k8sutil.FilterNodesByAnnotation(nodes.items, justRebootedSelector)
 	// Also filter out any nodes that are already labeled with after-reboot=true.
 	justRebootedNodes = k8sutil.FilterNodesByRequirement(justRebootedNodes, notAfterRebootReq)
 
@@ -528,24 +1406,29 @@ func (k *Kontroller) markAfterReboot() error {
 
 	// For all the nodes which just rebooted, remove any old annotations and add the after-reboot=true label.
 	for _, n := range justRebootedNodes {
-		err = k.mark(n.Name, constants.LabelAfterReboot, "after-reboot", k.afterRebootAnnotations)
+		err := k.mark(nodes, n.Name, constants.LabelAfterReboot, constants.AnnotationAfterRebootTime, "after-reboot",
+			k.afterRebootAnnotations)
 		if err != nil {
 			return fmt.Errorf("labeling node for after reboot checks: %w", err)
 		}
+
+		k.metrics.observePhaseCompleted(metricsPhaseRebooting, n, time.Now())
 	}
 
 	return nil
 }
 
-func (k *Kontroller) mark(nodeName, label, annotationsType string, annotations []string) error {
+func (k *Kontroller) mark(nodes *nodeSnapshot, nodeName, label, timeAnnotation, annotationsType string,
+	annotations []string) error {
 	klog.V(4).Infof("Deleting annotations %v for %q", annotations, nodeName)
 	klog.V(4).Infof("Setting label %q to %q for node %q", label, constants.True, nodeName)
 
-	err := k8sutil.UpdateNodeRetry(k.nc, nodeName, func(node *corev1.Node) {
+	err := nodes.update(nodeName, func(node *corev1.Node) {
 		for _, annotation := range annotations {
 			delete(node.Annotations, annotation)
 		}
 		node.Labels[label] = constants.True
+		node.Annotations[timeAnnotation] = time.Now().UTC().Format(time.RFC3339)
 	})
 	if err != nil {
 		return fmt.Errorf("setting label %q to %q on node %q: %w", label, constants.True, nodeName, err)
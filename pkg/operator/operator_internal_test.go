@@ -0,0 +1,80 @@
+package operator
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_leaderElectionTimings(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		config        Config
+		leaseDuration time.Duration
+		renewDeadline time.Duration
+		retryPeriod   time.Duration
+		expectErr     bool
+	}{
+		"defaults_when_not_set": {
+			leaseDuration: 90 * time.Second,
+			renewDeadline: 60 * time.Second,
+			retryPeriod:   30 * time.Second,
+		},
+		"custom_values": {
+			config: Config{
+				LeaderElectionLeaseDuration: 15 * time.Second,
+				LeaderElectionRenewDeadline: 10 * time.Second,
+				LeaderElectionRetryPeriod:   2 * time.Second,
+			},
+			leaseDuration: 15 * time.Second,
+			renewDeadline: 10 * time.Second,
+			retryPeriod:   2 * time.Second,
+		},
+		"renew_deadline_not_less_than_lease_duration": {
+			config: Config{
+				LeaderElectionLeaseDuration: 30 * time.Second,
+				LeaderElectionRenewDeadline: 30 * time.Second,
+			},
+			expectErr: true,
+		},
+		"retry_period_too_long_for_renew_deadline": {
+			config: Config{
+				LeaderElectionRenewDeadline: 10 * time.Second,
+				LeaderElectionRetryPeriod:   10 * time.Second,
+			},
+			expectErr: true,
+		},
+		"negative_value": {
+			config: Config{
+				LeaderElectionRetryPeriod: -time.Second,
+			},
+			expectErr: true,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			leaseDuration, renewDeadline, retryPeriod, err := leaderElectionTimings(c.config)
+			if c.expectErr {
+				if err == nil {
+					t.Fatalf("Expected error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if leaseDuration != c.leaseDuration || renewDeadline != c.renewDeadline || retryPeriod != c.retryPeriod {
+				t.Fatalf("Expected %v, %v and %v, got %v, %v and %v", c.leaseDuration, c.renewDeadline,
+					c.retryPeriod, leaseDuration, renewDeadline, retryPeriod)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blang/semver"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+const (
+	// NodeOrderingNone keeps nodes in the order returned by the API server.
+	NodeOrderingNone = "none"
+	// NodeOrderingFewestPods orders nodes by number of pods, which would be evicted by the drain,
+	// so nodes with fewest non-DaemonSet pods reboot first.
+	NodeOrderingFewestPods = "fewest-pods"
+	// NodeOrderingOldestVersion orders nodes by the version of Flatcar Container Linux they run,
+	// so nodes with oldest version reboot first.
+	NodeOrderingOldestVersion = "oldest-version"
+	// NodeOrderingLongestWaiting orders nodes by the time they requested a reboot, so nodes
+	// waiting the longest reboot first.
+	NodeOrderingLongestWaiting = "longest-waiting"
+	// NodeOrderingPriority orders nodes by the value of the reboot priority annotation, so
+	// nodes with highest priority reboot first.
+	NodeOrderingPriority = "priority"
+)
+
+// NodeOrdering decides in which order nodes which want to reboot are considered for rebooting.
+type NodeOrdering interface {
+	// Order returns given nodes in the order in which they should be rebooted.
+	Order(nodes []corev1.Node) ([]corev1.Node, error)
+}
+
+// NewNodeOrdering returns built-in NodeOrdering with the given name. Empty name selects
+// NodeOrderingNone. Client is only used by strategies which need to query the cluster.
+func NewNodeOrdering(name string, client kubernetes.Interface) (NodeOrdering, error) {
+	switch name {
+	case "", NodeOrderingNone:
+		return noOrdering{}, nil
+	case NodeOrderingFewestPods:
+		if client == nil {
+			return nil, fmt.Errorf("kubernetes client must not be nil")
+		}
+
+		return fewestPodsOrdering{client: client}, nil
+	case NodeOrderingOldestVersion:
+		return byKeyOrdering{less: olderVersion}, nil
+	case NodeOrderingLongestWaiting:
+		return byKeyOrdering{less: waitingLonger}, nil
+	case NodeOrderingPriority:
+		return byKeyOrdering{less: higherPriority}, nil
+	default:
+		return nil, fmt.Errorf("unknown node ordering %q, supported values are: %q, %q, %q, %q, %q", name,
+			NodeOrderingNone, NodeOrderingFewestPods, NodeOrderingOldestVersion, NodeOrderingLongestWaiting,
+			NodeOrderingPriority)
+	}
+}
+
+// noOrdering keeps the nodes in the given order.
+type noOrdering struct{}
+
+// Order implements NodeOrdering interface.
+func (noOrdering) Order(nodes []corev1.Node) ([]corev1.Node, error) {
+	return nodes, nil
+}
+
+// byKeyOrdering sorts nodes using given comparison function, keeping the original order of equal nodes.
+type byKeyOrdering struct {
+	less func(a, b corev1.Node) bool
+}
+
+// Order implements NodeOrdering interface.
+func (o byKeyOrdering) Order(nodes []corev1.Node) ([]corev1.Node, error) {
+	sorted := append([]corev1.Node{}, nodes...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return o.less(sorted[i], sorted[j])
+	})
+
+	return sorted, nil
+}
+
+// fewestPodsOrdering sorts nodes by number of pods, which would be deleted during the drain.
+type fewestPodsOrdering struct {
+	client kubernetes.Interface
+}
+
+// Order implements NodeOrdering interface.
+func (o fewestPodsOrdering) Order(nodes []corev1.Node) ([]corev1.Node, error) {
+	pods := map[string]int{}
+
+	for _, node := range nodes {
+		p, err := k8sutil.GetPodsForDeletion(o.client, node.Name)
+		if err != nil {
+			return nil, fmt.Errorf("getting pods for deletion on node %q: %w", node.Name, err)
+		}
+
+		pods[node.Name] = len(p)
+	}
+
+	return byKeyOrdering{less: func(a, b corev1.Node) bool {
+		return pods[a.Name] < pods[b.Name]
+	}}.Order(nodes)
+}
+
+// olderVersion returns true if node a runs older version than node b. Nodes with
+// unknown version are ordered last.
+func olderVersion(a, b corev1.Node) bool {
+	va, errA := semver.Parse(a.Labels[constants.LabelVersion])
+	vb, errB := semver.Parse(b.Labels[constants.LabelVersion])
+
+	switch {
+	case errA != nil:
+		return false
+	case errB != nil:
+		return true
+	default:
+		return va.LT(vb)
+	}
+}
+
+// waitingLonger returns true if node a requested a reboot before node b. Nodes with unknown
+// reboot request time are ordered last.
+func waitingLonger(a, b corev1.Node) bool {
+	ta, errA := time.Parse(time.RFC3339, a.Annotations[constants.AnnotationRebootNeededTime])
+	tb, errB := time.Parse(time.RFC3339, b.Annotations[constants.AnnotationRebootNeededTime])
+
+	switch {
+	case errA != nil:
+		return false
+	case errB != nil:
+		return true
+	default:
+		return ta.Before(tb)
+	}
+}
+
+// higherPriority returns true if node a has higher reboot priority than node b. Nodes
+// without valid priority annotation have priority 0.
+func higherPriority(a, b corev1.Node) bool {
+	return rebootPriority(a) > rebootPriority(b)
+}
+
+// rebootPriority returns the reboot priority of the node.
+func rebootPriority(node corev1.Node) int {
+	priority, err := strconv.Atoi(node.Annotations[constants.AnnotationRebootPriority])
+	if err != nil {
+		return 0
+	}
+
+	return priority
+}
@@ -0,0 +1,110 @@
+package operator_test
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/operator"
+)
+
+func names(nodes []corev1.Node) []string {
+	n := []string{}
+
+	for _, node := range nodes {
+		n = append(n, node.Name)
+	}
+
+	return n
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//nolint:funlen // Just many test cases.
+func TestNodeOrdering(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, labels, annotations map[string]string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+	}
+
+	cases := map[string]struct {
+		nodes    []corev1.Node
+		expected []string
+	}{
+		operator.NodeOrderingNone: {
+			nodes:    []corev1.Node{node("b", nil, nil), node("a", nil, nil)},
+			expected: []string{"b", "a"},
+		},
+		operator.NodeOrderingOldestVersion: {
+			nodes: []corev1.Node{
+				node("unknown", nil, nil),
+				node("new", map[string]string{constants.LabelVersion: "2765.2.0"}, nil),
+				node("old", map[string]string{constants.LabelVersion: "2605.12.0"}, nil),
+				node("older", map[string]string{constants.LabelVersion: "2605.8.0"}, nil),
+			},
+			expected: []string{"older", "old", "new", "unknown"},
+		},
+		operator.NodeOrderingLongestWaiting: {
+			nodes: []corev1.Node{
+				node("unknown", nil, nil),
+				node("recent", nil, map[string]string{constants.AnnotationRebootNeededTime: "2021-03-02T10:00:00Z"}),
+				node("waiting", nil, map[string]string{constants.AnnotationRebootNeededTime: "2021-03-01T10:00:00Z"}),
+			},
+			expected: []string{"waiting", "recent", "unknown"},
+		},
+		operator.NodeOrderingPriority: {
+			nodes: []corev1.Node{
+				node("default", nil, nil),
+				node("low", nil, map[string]string{constants.AnnotationRebootPriority: "-10"}),
+				node("high", nil, map[string]string{constants.AnnotationRebootPriority: "10"}),
+				node("invalid", nil, map[string]string{constants.AnnotationRebootPriority: "foo"}),
+			},
+			expected: []string{"high", "default", "invalid", "low"},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ordering, err := operator.NewNodeOrdering(name, nil)
+			if err != nil {
+				t.Fatalf("Creating node ordering: %v", err)
+			}
+
+			ordered, err := ordering.Order(c.nodes)
+			if err != nil {
+				t.Fatalf("Ordering nodes: %v", err)
+			}
+
+			if got := names(ordered); !equal(got, c.expected) {
+				t.Fatalf("Expected order %v, got %v", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewNodeOrdering_rejects_unknown_ordering(t *testing.T) {
+	t.Parallel()
+
+	if _, err := operator.NewNodeOrdering("random", nil); err == nil {
+		t.Fatalf("Expected error for unknown node ordering")
+	}
+}
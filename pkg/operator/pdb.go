@@ -0,0 +1,189 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+// pdbBudget keeps track of disruptions still allowed by PodDisruptionBudgets in the cluster,
+// so nodes whose drain would violate any of them are not selected for reboot.
+type pdbBudget struct {
+	kc         kubernetes.Interface
+	exclusions *k8sutil.DrainExclusions
+	pdbs       []policyv1.PodDisruptionBudget
+	remaining  map[string]int
+}
+
+// newPDBBudget creates pdbBudget from all PodDisruptionBudgets in the cluster. Only pods, which are
+// not excluded from the drain by given exclusions, are checked against them.
+//
+// Pods on the given pending nodes, which have been chosen for reboot, but have not been drained
+// yet, are still counted as healthy by the PodDisruptionBudgets, so their disruptions are claimed
+// from the budget upfront.
+func newPDBBudget(kc kubernetes.Interface, exclusions *k8sutil.DrainExclusions,
+	pendingNodes []corev1.Node) (*pdbBudget, error) {
+	pdbs, err := listPDBs(kc)
+	if err != nil {
+		return nil, fmt.Errorf("listing PodDisruptionBudgets: %w", err)
+	}
+
+	remaining := map[string]int{}
+
+	for _, pdb := range pdbs {
+		remaining[pdbKey(pdb)] = int(pdb.Status.DisruptionsAllowed)
+	}
+
+	b := &pdbBudget{
+		kc:         kc,
+		exclusions: exclusions,
+		pdbs:       pdbs,
+		remaining:  remaining,
+	}
+
+	for _, node := range pendingNodes {
+		disruptions, err := b.disruptions(node)
+		if err != nil {
+			return nil, fmt.Errorf("checking PodDisruptionBudgets for node %q: %w", node.Name, err)
+		}
+
+		b.take(disruptions)
+	}
+
+	return b, nil
+}
+
+// listPDBs returns all PodDisruptionBudgets in the cluster using the policy/v1 API. If it is not
+// served by the cluster, policy/v1beta1 API is used instead. If neither of them is served, no
+// PodDisruptionBudgets are returned.
+func listPDBs(kc kubernetes.Interface) ([]policyv1.PodDisruptionBudget, error) {
+	pdbList, err := kc.PolicyV1().PodDisruptionBudgets(corev1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err == nil {
+		return pdbList.Items, nil
+	}
+
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("using policy/v1 API: %w", err)
+	}
+
+	v1beta1List, err := kc.PolicyV1beta1().PodDisruptionBudgets(corev1.NamespaceAll).List(context.TODO(),
+		metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Warningf("PodDisruptionBudgets API is not served by the cluster, ignoring PodDisruptionBudgets")
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("using policy/v1beta1 API: %w", err)
+	}
+
+	pdbs := []policyv1.PodDisruptionBudget{}
+
+	for _, pdb := range v1beta1List.Items {
+		pdbs = append(pdbs, pdbFromV1beta1(pdb))
+	}
+
+	return pdbs, nil
+}
+
+// pdbFromV1beta1 converts given policy/v1beta1 PodDisruptionBudget to policy/v1 one, keeping the
+// fields used for selecting nodes for reboot.
+func pdbFromV1beta1(pdb policyv1beta1.PodDisruptionBudget) policyv1.PodDisruptionBudget {
+	selector := pdb.Spec.Selector
+
+	// In policy/v1beta1 empty selector matches no pods, while in policy/v1 it matches all pods
+	// in the namespace. Nil selector matches no pods in both versions.
+	if selector != nil && len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		selector = nil
+	}
+
+	return policyv1.PodDisruptionBudget{
+		ObjectMeta: pdb.ObjectMeta,
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: pdb.Status.DisruptionsAllowed},
+	}
+}
+
+// pdbKey returns unique key identifying the given PodDisruptionBudget.
+func pdbKey(pdb policyv1.PodDisruptionBudget) string {
+	return pdb.Namespace + "/" + pdb.Name
+}
+
+// disruptions returns number of pods covered by each PodDisruptionBudget, which would be
+// evicted when draining the given node.
+func (b *pdbBudget) disruptions(node corev1.Node) (map[string]int, error) {
+	pods, err := k8sutil.GetPodsToEvict(b.kc, node.Name, b.exclusions)
+	if err != nil {
+		return nil, fmt.Errorf("getting pods to evict: %w", err)
+	}
+
+	return b.podDisruptions(pods)
+}
+
+// podDisruptions returns number of given pods covered by each PodDisruptionBudget.
+func (b *pdbBudget) podDisruptions(pods []corev1.Pod) (map[string]int, error) {
+	disruptions := map[string]int{}
+
+	for _, pod := range pods {
+		for _, pdb := range b.pdbs {
+			matches, err := pdbMatchesPod(pdb, pod)
+			if err != nil {
+				return nil, err
+			}
+
+			if matches {
+				disruptions[pdbKey(pdb)]++
+			}
+		}
+	}
+
+	return disruptions, nil
+}
+
+// pdbMatchesPod returns true if given PodDisruptionBudget covers given pod.
+func pdbMatchesPod(pdb policyv1.PodDisruptionBudget, pod corev1.Pod) (bool, error) {
+	if pdb.Namespace != pod.Namespace {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		return false, fmt.Errorf("parsing selector of PodDisruptionBudget %q: %w", pdbKey(pdb), err)
+	}
+
+	// Like the Eviction API, empty selector matches all pods in the namespace and nil selector
+	// matches no pods.
+	return selector.Matches(labels.Set(pod.Labels)), nil
+}
+
+// violated returns key of the first PodDisruptionBudget, which does not allow given disruptions.
+// If all disruptions are allowed, empty string is returned.
+func (b *pdbBudget) violated(disruptions map[string]int) string {
+	for _, pdb := range b.pdbs {
+		key := pdbKey(pdb)
+
+		if disruptions[key] > b.remaining[key] {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// take claims given disruptions from the remaining budget of each PodDisruptionBudget.
+func (b *pdbBudget) take(disruptions map[string]int) {
+	for key, count := range disruptions {
+		b.remaining[key] -= count
+	}
+}
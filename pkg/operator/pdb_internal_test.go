@@ -0,0 +1,287 @@
+package operator
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+func Test_pdbMatchesPod_requires_same_namespace_and_matching_selector(t *testing.T) {
+	t.Parallel()
+
+	pdb := func(namespace string, selector *metav1.LabelSelector) policyv1.PodDisruptionBudget {
+		return policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: namespace},
+			Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+		}
+	}
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "etcd"}},
+	}
+
+	cases := map[string]struct {
+		pdb     policyv1.PodDisruptionBudget
+		matches bool
+	}{
+		"matching_selector": {
+			pdb:     pdb("default", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "etcd"}}),
+			matches: true,
+		},
+		"other_namespace": {
+			pdb: pdb("other", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "etcd"}}),
+		},
+		"other_labels": {
+			pdb: pdb("default", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}),
+		},
+		"empty_selector": {
+			pdb:     pdb("default", &metav1.LabelSelector{}),
+			matches: true,
+		},
+		"nil_selector": {
+			pdb: pdb("default", nil),
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			matches, err := pdbMatchesPod(c.pdb, pod)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if matches != c.matches {
+				t.Fatalf("Expected match %v, got %v", c.matches, matches)
+			}
+		})
+	}
+}
+
+func Test_pdbBudget_take_lowers_allowed_disruptions(t *testing.T) {
+	t.Parallel()
+
+	pdb := policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "default"}}
+
+	b := &pdbBudget{
+		pdbs:      []policyv1.PodDisruptionBudget{pdb},
+		remaining: map[string]int{pdbKey(pdb): 1},
+	}
+
+	disruptions := map[string]int{pdbKey(pdb): 1}
+
+	if v := b.violated(disruptions); v != "" {
+		t.Fatalf("Expected first disruption to be allowed, got violated PodDisruptionBudget %q", v)
+	}
+
+	b.take(disruptions)
+
+	if v := b.violated(disruptions); v != pdbKey(pdb) {
+		t.Fatalf("Expected second disruption to violate PodDisruptionBudget %q, got %q", pdbKey(pdb), v)
+	}
+
+	if v := b.violated(map[string]int{}); v != "" {
+		t.Fatalf("Expected node without covered pods to be allowed, got violated PodDisruptionBudget %q", v)
+	}
+}
+
+// fakeClientWithPods returns fake client with given PodDisruptionBudgets and pods. Listed pods are
+// filtered by node name, as the fake client ignores field selectors.
+func fakeClientWithPods(pdbs []policyv1.PodDisruptionBudget, pods []corev1.Pod) *fake.Clientset {
+	objects := []runtime.Object{}
+
+	for i := range pdbs {
+		objects = append(objects, &pdbs[i])
+	}
+
+	kc := fake.NewSimpleClientset(objects...)
+
+	kc.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		node, _ := action.(k8stesting.ListAction).GetListRestrictions().Fields.RequiresExactMatch("spec.nodeName")
+
+		list := &corev1.PodList{}
+
+		for _, pod := range pods {
+			if pod.Spec.NodeName == node {
+				list.Items = append(list.Items, pod)
+			}
+		}
+
+		return true, list, nil
+	})
+
+	return kc
+}
+
+//nolint:funlen // Just many test cases.
+func Test_newPDBBudget(t *testing.T) {
+	t.Parallel()
+
+	pdb := func(namespace, app string, allowed int32) policyv1.PodDisruptionBudget {
+		return policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: app, Namespace: namespace},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+			},
+			Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+		}
+	}
+
+	pod := func(name, namespace, app, node string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": app}},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+
+	node := func(name string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+
+	cases := map[string]struct {
+		pdbs     []policyv1.PodDisruptionBudget
+		pods     []corev1.Pod
+		pending  []corev1.Node
+		violated string
+	}{
+		"allowed_disruption": {
+			pdbs: []policyv1.PodDisruptionBudget{pdb("default", "web", 1)},
+			pods: []corev1.Pod{pod("web-a", "default", "web", "a")},
+		},
+		"disruption_claimed_by_pending_node": {
+			pdbs: []policyv1.PodDisruptionBudget{pdb("default", "web", 1)},
+			pods: []corev1.Pod{
+				pod("web-a", "default", "web", "a"),
+				pod("web-b", "default", "web", "b"),
+			},
+			pending:  []corev1.Node{node("b")},
+			violated: "default/web",
+		},
+		"drained_pending_node": {
+			pdbs:    []policyv1.PodDisruptionBudget{pdb("default", "web", 1)},
+			pods:    []corev1.Pod{pod("web-a", "default", "web", "a")},
+			pending: []corev1.Node{node("b")},
+		},
+		"excluded_pods": {
+			pdbs: []policyv1.PodDisruptionBudget{pdb("kube-system", "coredns", 0)},
+			pods: []corev1.Pod{pod("coredns-a", "kube-system", "coredns", "a")},
+		},
+		"not_allowed_disruption": {
+			pdbs:     []policyv1.PodDisruptionBudget{pdb("default", "web", 0)},
+			pods:     []corev1.Pod{pod("web-a", "default", "web", "a")},
+			violated: "default/web",
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			exclusions, err := k8sutil.NewDrainExclusions(k8sutil.DefaultDrainExcludedNamespaces, "")
+			if err != nil {
+				t.Fatalf("Creating drain exclusions: %v", err)
+			}
+
+			b, err := newPDBBudget(fakeClientWithPods(c.pdbs, c.pods), exclusions, c.pending)
+			if err != nil {
+				t.Fatalf("Creating PodDisruptionBudget budget: %v", err)
+			}
+
+			disruptions, err := b.disruptions(node("a"))
+			if err != nil {
+				t.Fatalf("Getting disruptions: %v", err)
+			}
+
+			if violated := b.violated(disruptions); violated != c.violated {
+				t.Fatalf("Expected violated PodDisruptionBudget %q, got %q", c.violated, violated)
+			}
+		})
+	}
+}
+
+// notServedReactor returns reactor failing list of PodDisruptionBudgets in given API versions with
+// not found error, like the API server does for API versions it does not serve.
+func notServedReactor(versions ...string) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		resource := action.GetResource()
+
+		for _, version := range versions {
+			if resource.Version == version {
+				return true, nil, apierrors.NewNotFound(resource.GroupResource(), "")
+			}
+		}
+
+		return false, nil, nil
+	}
+}
+
+func Test_newPDBBudget_falls_back_to_policy_v1beta1_API_when_policy_v1_API_is_not_served(t *testing.T) {
+	t.Parallel()
+
+	pdbs := []runtime.Object{
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "default"},
+			Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{}},
+		},
+	}
+
+	kc := fake.NewSimpleClientset(pdbs...)
+	kc.PrependReactor("list", "poddisruptionbudgets", notServedReactor("v1"))
+
+	b, err := newPDBBudget(kc, &k8sutil.DrainExclusions{}, nil)
+	if err != nil {
+		t.Fatalf("Creating PodDisruptionBudget budget: %v", err)
+	}
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-a", Namespace: "default", Labels: map[string]string{"app": "web"}},
+	}
+
+	disruptions, err := b.podDisruptions([]corev1.Pod{pod})
+	if err != nil {
+		t.Fatalf("Getting disruptions: %v", err)
+	}
+
+	expected := map[string]int{"default/web": 1}
+
+	if !reflect.DeepEqual(expected, disruptions) {
+		t.Fatalf("Expected disruptions %v, got %v", expected, disruptions)
+	}
+}
+
+func Test_newPDBBudget_ignores_PodDisruptionBudgets_when_no_policy_API_is_served(t *testing.T) {
+	t.Parallel()
+
+	kc := fake.NewSimpleClientset()
+	kc.PrependReactor("list", "poddisruptionbudgets", notServedReactor("v1", "v1beta1"))
+
+	b, err := newPDBBudget(kc, &k8sutil.DrainExclusions{}, nil)
+	if err != nil {
+		t.Fatalf("Expected PodDisruptionBudgets to be ignored, got: %v", err)
+	}
+
+	if len(b.pdbs) != 0 {
+		t.Fatalf("Expected no PodDisruptionBudgets, got %v", b.pdbs)
+	}
+}
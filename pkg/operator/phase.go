@@ -0,0 +1,249 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+// rebootPhase describes the before-reboot or after-reboot phase, in which a node waits for
+// configured annotations.
+type rebootPhase struct {
+	name           string
+	req            *labels.Requirement
+	label          string
+	annotations    []string
+	timeAnnotation string
+	timeout        time.Duration
+}
+
+// phases returns before-reboot and after-reboot phases.
+func (k *Kontroller) phases() []rebootPhase {
+	return []rebootPhase{
+		{
+			name:           rebootPhaseBefore,
+			req:            beforeRebootReq,
+			label:          constants.LabelBeforeReboot,
+			annotations:    k.beforeRebootAnnotations,
+			timeAnnotation: constants.AnnotationBeforeRebootTime,
+			timeout:        k.beforeRebootTimeout,
+		},
+		{
+			name:           rebootPhaseAfter,
+			req:            afterRebootReq,
+			label:          constants.LabelAfterReboot,
+			annotations:    k.afterRebootAnnotations,
+			timeAnnotation: constants.AnnotationAfterRebootTime,
+			timeout:        k.afterRebootTimeout,
+		},
+	}
+}
+
+// phaseFailure returns the reason why the node failed the given phase. Node fails the phase, when
+// any of the phase annotations is set to a failure value or when the phase timeout has passed.
+// If the node has not failed, empty string is returned.
+func phaseFailure(node corev1.Node, phase rebootPhase, now time.Time) string {
+	for _, annotation := range phase.annotations {
+		switch value := node.Annotations[annotation]; value {
+		case constants.False, constants.Failed:
+			return fmt.Sprintf("annotation %q is set to %q", annotation, value)
+		}
+	}
+
+	// Node which passed all checks is going to leave the phase.
+	if phase.timeout == 0 || hasAllAnnotations(node, phase.annotations) {
+		return ""
+	}
+
+	start, err := time.Parse(time.RFC3339, node.Annotations[phase.timeAnnotation])
+	if err != nil {
+		// Nodes labeled by older versions of the operator are not tracked.
+		return ""
+	}
+
+	if now.Sub(start) > phase.timeout {
+		return fmt.Sprintf("annotations were not set to %q within %v", constants.True, phase.timeout)
+	}
+
+	return ""
+}
+
+// abortFailedPhases finds nodes which failed the before-reboot or after-reboot phase and aborts
+// their reboot. The phase label, annotations and the phase start time are removed from the node
+// and an Event is emitted.
+//
+// Nodes which failed the before-reboot phase do not reboot, they are backed off and then put at the
+// back of the queue of nodes waiting for a reboot. Nodes which failed the after-reboot phase are marked as
+// failed, which halts all further reboots until an administrator clears the failure.
+//
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) abortFailedPhases(nodes *nodeSnapshot) error {
+	now := time.Now()
+
+	for _, phase := range k.phases() {
+		for _, n := range k8sutil.FilterNodesByRequirement(nodes.items, phase.req) {
+			n := n
+
+			reason := phaseFailure(n, phase, now)
+			if reason == "" {
+				continue
+			}
+
+			if err := k.abortPhase(nodes, n, phase, reason, now); err != nil {
+				return fmt.Errorf("aborting %s phase of node %q: %w", phase.name, n.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// abortPhase aborts the reboot of the node, which failed the given phase.
+func (k *Kontroller) abortPhase(nodes *nodeSnapshot, n corev1.Node, phase rebootPhase, reason string,
+	now time.Time) error {
+	klog.Warningf("Node %q failed %s checks, aborting reboot: %s", n.Name, phase.name, reason)
+
+	err := nodes.update(n.Name, func(node *corev1.Node) {
+		delete(node.Labels, phase.label)
+
+		for _, annotation := range phase.annotations {
+			delete(node.Annotations, annotation)
+		}
+
+		delete(node.Annotations, phase.timeAnnotation)
+
+		if phase.name == rebootPhaseBefore {
+			node.Annotations[constants.AnnotationRebootAbortedTime] = now.UTC().Format(time.RFC3339)
+
+			return
+		}
+
+		// Node has already rebooted, so release it, but halt further reboots.
+		node.Annotations[constants.AnnotationOkToReboot] = constants.False
+		node.Annotations[constants.AnnotationRebootFailed] = constants.True
+	})
+	if err != nil {
+		return fmt.Errorf("updating node: %w", err)
+	}
+
+	if phase.name == rebootPhaseBefore {
+		k.nodeEventf(&n, corev1.EventTypeWarning, "RebootAborted",
+			"Reboot aborted, %s checks failed: %s. Node will be backed off and considered for reboot after other nodes",
+			phase.name, reason)
+
+		return nil
+	}
+
+	k.nodeEventf(&n, corev1.EventTypeWarning, "RebootAborted",
+		"Reboot aborted, %s checks failed: %s. Halting all reboots. Set annotation %q to %q to resume",
+		phase.name, reason, constants.AnnotationRebootFailed, constants.False)
+
+	return nil
+}
+
+// releaseAbortedReboots finds nodes, whose reboot has been aborted by the update-agent, e.g. because
+// draining the node timed out, and releases their reboot slot by setting reboot-ok to false. The time
+// of the abort is recorded, so the node is backed off and then considered for reboot after other nodes.
+//
+// If there is an error updating any of the nodes, an error is immediately returned.
+func (k *Kontroller) releaseAbortedReboots(nodes *nodeSnapshot) error {
+	now := time.Now()
+
+	for _, n := range k8sutil.FilterNodesByAnnotation(nodes.items, rebootAbortedSelector) {
+		n := n
+
+		klog.Warningf("Reboot of node %q has been aborted by update-agent, releasing it", n.Name)
+
+		err := nodes.update(n.Name, func(node *corev1.Node) {
+			node.Annotations[constants.AnnotationOkToReboot] = constants.False
+			node.Annotations[constants.AnnotationRebootAbortedTime] = now.UTC().Format(time.RFC3339)
+			delete(node.Annotations, constants.AnnotationRebootAborted)
+		})
+		if err != nil {
+			return fmt.Errorf("releasing node %q: %w", n.Name, err)
+		}
+
+		k.nodeEventf(&n, corev1.EventTypeWarning, "RebootAborted",
+			"Reboot aborted by update-agent. Node will be backed off and considered for reboot after other nodes")
+	}
+
+	return nil
+}
+
+// abortedLast returns given nodes with nodes, whose reboot has been aborted, moved to the end,
+// ordered by the time of the abort. Order of other nodes is preserved.
+func abortedLast(nodes []corev1.Node) []corev1.Node {
+	sorted := append([]corev1.Node{}, nodes...)
+
+	abortedTime := func(node corev1.Node) (time.Time, bool) {
+		t, err := time.Parse(time.RFC3339, node.Annotations[constants.AnnotationRebootAbortedTime])
+
+		return t, err == nil
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, abortedI := abortedTime(sorted[i])
+		tj, abortedJ := abortedTime(sorted[j])
+
+		if abortedI && abortedJ {
+			return ti.Before(tj)
+		}
+
+		return !abortedI && abortedJ
+	})
+
+	return sorted
+}
+
+// abortBackoff returns the time which must pass since the reboot of the given node has been aborted,
+// before the node may be chosen for reboot again, so a node failing its checks is not re-selected
+// and aborted in a loop. Nodes, which update-agent refused to drain, may be backed off longer, as
+// the drain is likely to be refused again until the offending pods are changed.
+func (k *Kontroller) abortBackoff(node corev1.Node) time.Duration {
+	if node.Annotations[constants.AnnotationDrainRefused] != "" && k.drainRefusedBackoff > k.rebootAbortBackoff {
+		return k.drainRefusedBackoff
+	}
+
+	return k.rebootAbortBackoff
+}
+
+// backedOffUntil returns the time until which the given node is not chosen for reboot, because its
+// reboot has been aborted. If the node is not backed off, false is returned.
+func (k *Kontroller) backedOffUntil(node corev1.Node) (time.Time, bool) {
+	backoff := k.abortBackoff(node)
+	if backoff == 0 {
+		return time.Time{}, false
+	}
+
+	aborted, err := time.Parse(time.RFC3339, node.Annotations[constants.AnnotationRebootAbortedTime])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return aborted.Add(backoff), true
+}
+
+// filterBackedOffNodes returns given nodes without nodes, which are backed off at the given time
+// after their reboot has been aborted.
+func (k *Kontroller) filterBackedOffNodes(nodes []corev1.Node, t time.Time) []corev1.Node {
+	var filtered []corev1.Node
+
+	for _, node := range nodes {
+		if until, ok := k.backedOffUntil(node); ok && t.Before(until) {
+			klog.V(4).Infof("Node %q is backed off after aborted reboot until %s", node.Name, until.Format(time.RFC3339))
+
+			continue
+		}
+
+		filtered = append(filtered, node)
+	}
+
+	return filtered
+}
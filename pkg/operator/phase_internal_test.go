@@ -0,0 +1,237 @@
+package operator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func Test_phaseFailure(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	phase := rebootPhase{
+		name:           rebootPhaseBefore,
+		annotations:    []string{"check"},
+		timeAnnotation: constants.AnnotationBeforeRebootTime,
+		timeout:        time.Hour,
+	}
+
+	node := func(check string, start time.Time) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "foo",
+				Annotations: map[string]string{
+					"check":                              check,
+					constants.AnnotationBeforeRebootTime: start.UTC().Format(time.RFC3339),
+				},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		node   corev1.Node
+		failed bool
+	}{
+		"waiting":       {node: node("", now.Add(-time.Minute))},
+		"succeeded":     {node: node(constants.True, now.Add(-2*time.Hour))},
+		"false_value":   {node: node(constants.False, now.Add(-time.Minute)), failed: true},
+		"failed_value":  {node: node(constants.Failed, now.Add(-time.Minute)), failed: true},
+		"timed_out":     {node: node("", now.Add(-2*time.Hour)), failed: true},
+		"unknown_start": {node: corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if reason := phaseFailure(c.node, phase, now); (reason != "") != c.failed {
+				t.Fatalf("Expected failed %v, got reason %q", c.failed, reason)
+			}
+		})
+	}
+}
+
+func Test_abortedLast_moves_aborted_nodes_to_the_end(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	node := func(name string, aborted time.Time) corev1.Node {
+		n := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+
+		if !aborted.IsZero() {
+			n.Annotations[constants.AnnotationRebootAbortedTime] = aborted.UTC().Format(time.RFC3339)
+		}
+
+		return n
+	}
+
+	nodes := []corev1.Node{
+		node("aborted-later", now),
+		node("a", time.Time{}),
+		node("aborted-earlier", now.Add(-time.Hour)),
+		node("b", time.Time{}),
+	}
+
+	sorted := abortedLast(nodes)
+
+	expected := []string{"a", "b", "aborted-earlier", "aborted-later"}
+
+	for i, name := range expected {
+		if sorted[i].Name != name {
+			t.Fatalf("Expected node %q at position %d, got %q", name, i, sorted[i].Name)
+		}
+	}
+}
+
+func Test_releaseAbortedReboots_revokes_reboot_permission_of_aborted_nodes(t *testing.T) {
+	t.Parallel()
+
+	aborted := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "aborted",
+			ResourceVersion: "1",
+			// Annotations set by the update-agent when it aborts the reboot.
+			Annotations: map[string]string{
+				constants.AnnotationOkToReboot:       constants.True,
+				constants.AnnotationRebootNeeded:     constants.False,
+				constants.AnnotationRebootInProgress: constants.False,
+				constants.AnnotationRebootAborted:    constants.True,
+			},
+		},
+	}
+
+	if justRebootedSelector.Matches(fields.Set(aborted.Annotations)) {
+		t.Fatalf("Node with aborted reboot should not be considered rebooted")
+	}
+
+	kc := fake.NewSimpleClientset(aborted)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	if err := indexer.Add(aborted); err != nil {
+		t.Fatalf("Adding node: %v", err)
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	nodes, err := newNodeSnapshot(kc.CoreV1().Nodes(), indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	recorder := record.NewFakeRecorder(1)
+	k := &Kontroller{er: recorder}
+
+	if err := k.releaseAbortedReboots(nodes); err != nil {
+		t.Fatalf("Releasing aborted reboots: %v", err)
+	}
+
+	annotations := nodes.items[0].Annotations
+
+	if annotations[constants.AnnotationOkToReboot] != constants.False {
+		t.Errorf("Expected reboot permission to be revoked, got annotations %v", annotations)
+	}
+
+	if _, ok := annotations[constants.AnnotationRebootAborted]; ok {
+		t.Errorf("Expected annotation %q to be removed", constants.AnnotationRebootAborted)
+	}
+
+	if annotations[constants.AnnotationRebootAbortedTime] == "" {
+		t.Errorf("Expected time of the abort to be recorded")
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Errorf("Expected RebootAborted event to be emitted")
+	}
+}
+
+//nolint:funlen // Just many test cases.
+func Test_filterBackedOffNodes_skips_aborted_nodes_until_backoff_expires(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	node := func(name, drainRefused string, aborted time.Time) corev1.Node {
+		n := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+
+		if drainRefused != "" {
+			n.Annotations[constants.AnnotationDrainRefused] = drainRefused
+		}
+
+		if !aborted.IsZero() {
+			n.Annotations[constants.AnnotationRebootAbortedTime] = aborted.UTC().Format(time.RFC3339)
+		}
+
+		return n
+	}
+
+	nodes := []corev1.Node{
+		node("not-aborted", "", time.Time{}),
+		node("aborted-recently", "", now.Add(-time.Minute)),
+		node("aborted-long-ago", "", now.Add(-20*time.Minute)),
+		node("refused-recently", "pod uses local storage", now.Add(-20*time.Minute)),
+		node("refused-long-ago", "pod uses local storage", now.Add(-2*time.Hour)),
+	}
+
+	cases := map[string]struct {
+		rebootAbortBackoff  time.Duration
+		drainRefusedBackoff time.Duration
+		expected            []string
+	}{
+		"aborted_and_refusing_nodes_are_backed_off": {
+			rebootAbortBackoff:  10 * time.Minute,
+			drainRefusedBackoff: time.Hour,
+			expected:            []string{"not-aborted", "aborted-long-ago", "refused-long-ago"},
+		},
+		"shorter_drain_refused_backoff_is_ignored": {
+			rebootAbortBackoff:  30 * time.Minute,
+			drainRefusedBackoff: time.Minute,
+			expected:            []string{"not-aborted", "refused-long-ago"},
+		},
+		"only_refusing_nodes_are_backed_off_with_disabled_abort_backoff": {
+			drainRefusedBackoff: time.Hour,
+			expected:            []string{"not-aborted", "aborted-recently", "aborted-long-ago", "refused-long-ago"},
+		},
+		"no_node_is_skipped_with_disabled_backoff": {
+			expected: []string{
+				"not-aborted", "aborted-recently", "aborted-long-ago", "refused-recently", "refused-long-ago",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			k := &Kontroller{rebootAbortBackoff: c.rebootAbortBackoff, drainRefusedBackoff: c.drainRefusedBackoff}
+
+			filtered := k.filterBackedOffNodes(nodes, now)
+
+			names := []string{}
+
+			for _, n := range filtered {
+				names = append(names, n.Name)
+			}
+
+			if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+				t.Fatalf("Expected nodes %v, got %v", c.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/locksmith/pkg/timeutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// weekdays are short day names accepted by timeutil.ParsePeriodic, ordered by their position in the week.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// rebootWindow is a set of periodic time windows, evaluated in a specific time zone.
+type rebootWindow struct {
+	periods  []*timeutil.Periodic
+	location *time.Location
+}
+
+// parseRebootWindow parses reboot window specification in the format
+// '[<days>] <time of day> <length> <time zone>', e.g. 'Mon-Fri 22:00 4h Europe/Berlin'.
+//
+// Days are optional and may be given as comma-separated list of short day names and day
+// ranges, e.g. 'Mon,Wed,Fri' or 'Sat-Mon'. If days are not specified, the window repeats
+// every day. The time zone must be a name from the IANA Time Zone database, e.g. 'UTC'.
+func parseRebootWindow(spec string) (*rebootWindow, error) {
+	f := strings.Fields(spec)
+
+	var days []string
+
+	switch len(f) {
+	case 3: //nolint:mnd // Time of day, length and time zone.
+	case 4: //nolint:mnd // Days, time of day, length and time zone.
+		d, err := parseDays(f[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing days %q: %w", f[0], err)
+		}
+
+		days = d
+		f = f[1:]
+	default:
+		return nil, fmt.Errorf("expected format '[<days>] <time of day> <length> <time zone>', got %q", spec)
+	}
+
+	location, err := time.LoadLocation(f[2])
+	if err != nil {
+		return nil, fmt.Errorf("loading time zone %q: %w", f[2], err)
+	}
+
+	if len(days) == 0 {
+		// Empty day means every day.
+		days = []string{""}
+	}
+
+	rw := &rebootWindow{
+		location: location,
+	}
+
+	for _, day := range days {
+		period, err := timeutil.ParsePeriodic(strings.TrimSpace(day+" "+f[0]), f[1])
+		if err != nil {
+			return nil, fmt.Errorf("parsing period: %w", err)
+		}
+
+		rw.periods = append(rw.periods, period)
+	}
+
+	return rw, nil
+}
+
+// newLegacyRebootWindow creates reboot window from a single start and length specification
+// evaluated in the local time zone, as accepted by timeutil.ParsePeriodic.
+func newLegacyRebootWindow(start, length string) (*rebootWindow, error) {
+	period, err := timeutil.ParsePeriodic(start, length)
+	if err != nil {
+		return nil, fmt.Errorf("parsing period: %w", err)
+	}
+
+	return &rebootWindow{
+		periods:  []*timeutil.Periodic{period},
+		location: time.Local,
+	}, nil
+}
+
+// parseDays parses comma-separated list of short day names and day ranges, e.g. 'Mon-Wed,Fri'.
+func parseDays(spec string) ([]string, error) {
+	days := []string{}
+
+	for _, item := range strings.Split(spec, ",") {
+		bounds := strings.Split(item, "-")
+
+		switch len(bounds) {
+		case 1:
+			day, err := weekday(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+
+			days = append(days, weekdays[day])
+		case 2: //nolint:mnd // Start and end of the range.
+			start, err := weekday(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := weekday(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+
+			// Ranges may wrap around the end of the week, e.g. 'Fri-Mon'.
+			for day := start; ; day = (day + 1) % len(weekdays) {
+				days = append(days, weekdays[day])
+
+				if day == end {
+					break
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid day range %q", item)
+		}
+	}
+
+	return days, nil
+}
+
+// weekday returns position of the given short day name in the week.
+func weekday(name string) (int, error) {
+	for i, day := range weekdays {
+		if strings.ToLower(name) == day {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid day of week %q", name)
+}
+
+// contains returns true if given time falls inside any of the window periods.
+func (rw *rebootWindow) contains(t time.Time) bool {
+	t = t.In(rw.location)
+
+	for _, period := range rw.periods {
+		// Get previous occurrence relative to given time and check if it has not ended yet.
+		if period.Previous(t).End.After(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// insideRebootWindows returns true if no reboot windows are given or if given time falls
+// inside any of them.
+func insideRebootWindows(windows []*rebootWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+
+	for _, rw := range windows {
+		if rw.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PoolRebootWindow configures reboot windows for a pool of nodes selected by labels.
+type PoolRebootWindow struct {
+	// Label selector for nodes in the pool, e.g. 'node.kubernetes.io/pool=gpu'.
+	Selector string
+	// Reboot windows in the same format as Config.RebootWindows.
+	Windows []string
+}
+
+// poolRebootWindow is a parsed PoolRebootWindow.
+type poolRebootWindow struct {
+	selector labels.Selector
+	windows  []*rebootWindow
+}
+
+// parsePoolRebootWindow parses given pool reboot window configuration.
+func parsePoolRebootWindow(pool PoolRebootWindow) (*poolRebootWindow, error) {
+	selector, err := labels.Parse(pool.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing selector %q: %w", pool.Selector, err)
+	}
+
+	if selector.Empty() {
+		return nil, fmt.Errorf("selector must not be empty")
+	}
+
+	if len(pool.Windows) == 0 {
+		return nil, fmt.Errorf("at least one reboot window must be configured for selector %q", pool.Selector)
+	}
+
+	prw := &poolRebootWindow{
+		selector: selector,
+	}
+
+	for _, spec := range pool.Windows {
+		rw, err := parseRebootWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("parsing reboot window %q: %w", spec, err)
+		}
+
+		prw.windows = append(prw.windows, rw)
+	}
+
+	return prw, nil
+}
+
+// rebootWindowsFor returns reboot windows which apply to the given node. Control plane nodes
+// use dedicated control plane reboot windows, if configured. Otherwise, windows of the first
+// node pool selecting the node are used. If no pool selects the node, global reboot windows
+// are used.
+func (k *Kontroller) rebootWindowsFor(node corev1.Node) []*rebootWindow {
+	if len(k.controlPlaneRebootWindows) > 0 && k.isControlPlane(node) {
+		return k.controlPlaneRebootWindows
+	}
+
+	for _, pool := range k.poolRebootWindows {
+		if pool.selector.Matches(labels.Set(node.Labels)) {
+			return pool.windows
+		}
+	}
+
+	return k.rebootWindows
+}
+
+// filterNodesInsideRebootWindows returns nodes for which given time falls inside their reboot windows.
+func (k *Kontroller) filterNodesInsideRebootWindows(nodes []corev1.Node, t time.Time) []corev1.Node {
+	var inside []corev1.Node
+
+	for _, node := range nodes {
+		if !insideRebootWindows(k.rebootWindowsFor(node), t) {
+			klog.V(4).Infof("Node %q is outside of its reboot windows", node.Name)
+
+			continue
+		}
+
+		inside = append(inside, node)
+	}
+
+	return inside
+}
@@ -0,0 +1,128 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_parseRebootWindow_rejects_invalid_specifications(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"",
+		"22:00 4h",
+		"Mon-Fri 22:00 4h",
+		"Mon-Fri 22:00 4h Mars/Olympus_Mons",
+		"Foo 22:00 4h UTC",
+		"Mon-Foo 22:00 4h UTC",
+		"Mon-Tue-Wed 22:00 4h UTC",
+		"25:00 1h UTC",
+		"22:00 25h UTC",
+	} {
+		if _, err := parseRebootWindow(spec); err == nil {
+			t.Errorf("Expected error when parsing %q", spec)
+		}
+	}
+}
+
+func Test_rebootWindow_contains(t *testing.T) {
+	t.Parallel()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Loading time zone: %v", err)
+	}
+
+	weekdayNights, err := parseRebootWindow("Mon-Fri 22:00 4h Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Parsing reboot window: %v", err)
+	}
+
+	weekends, err := parseRebootWindow("Sat,Sun 08:00 12h Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Parsing reboot window: %v", err)
+	}
+
+	windows := []*rebootWindow{weekdayNights, weekends}
+
+	cases := []struct {
+		time     time.Time
+		expected bool
+	}{
+		// Tuesday 23:00 in Berlin.
+		{time.Date(2021, time.March, 2, 23, 0, 0, 0, berlin), true},
+		// Tuesday 23:00 in Berlin expressed in UTC.
+		{time.Date(2021, time.March, 2, 22, 0, 0, 0, time.UTC), true},
+		// Wednesday 01:30 in Berlin, window started on Tuesday.
+		{time.Date(2021, time.March, 3, 1, 30, 0, 0, berlin), true},
+		// Wednesday 12:00 in Berlin.
+		{time.Date(2021, time.March, 3, 12, 0, 0, 0, berlin), false},
+		// Saturday 01:00 in Berlin, window started on Friday.
+		{time.Date(2021, time.March, 6, 1, 0, 0, 0, berlin), true},
+		// Saturday 12:00 in Berlin.
+		{time.Date(2021, time.March, 6, 12, 0, 0, 0, berlin), true},
+		// Sunday 21:00 in Berlin.
+		{time.Date(2021, time.March, 7, 21, 0, 0, 0, berlin), false},
+	}
+
+	for _, c := range cases {
+		if got := insideRebootWindows(windows, c.time); got != c.expected {
+			t.Errorf("Expected %v for %v, got %v", c.expected, c.time, got)
+		}
+	}
+}
+
+func Test_insideRebootWindows_allows_any_time_without_windows(t *testing.T) {
+	t.Parallel()
+
+	if !insideRebootWindows(nil, time.Now()) {
+		t.Fatalf("Any time should be allowed when no reboot windows are configured")
+	}
+}
+
+func Test_rebootWindowsFor_uses_windows_of_first_matching_pool(t *testing.T) {
+	t.Parallel()
+
+	k := &Kontroller{}
+
+	global, err := parseRebootWindow("03:00 1h UTC")
+	if err != nil {
+		t.Fatalf("Parsing reboot window: %v", err)
+	}
+
+	k.rebootWindows = []*rebootWindow{global}
+
+	for _, pool := range []PoolRebootWindow{
+		{Selector: "pool=gpu", Windows: []string{"Sat 02:00 6h UTC"}},
+		{Selector: "pool in (gpu, web)", Windows: []string{"Mon-Fri 22:00 2h UTC"}},
+	} {
+		prw, err := parsePoolRebootWindow(pool)
+		if err != nil {
+			t.Fatalf("Parsing pool reboot window: %v", err)
+		}
+
+		k.poolRebootWindows = append(k.poolRebootWindows, prw)
+	}
+
+	node := func(pool string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"pool": pool}}}
+	}
+
+	// Saturday 04:00 UTC.
+	saturday := time.Date(2021, time.March, 6, 4, 0, 0, 0, time.UTC)
+
+	if !insideRebootWindows(k.rebootWindowsFor(node("gpu")), saturday) {
+		t.Errorf("Node from GPU pool should be inside its reboot window")
+	}
+
+	if insideRebootWindows(k.rebootWindowsFor(node("web")), saturday) {
+		t.Errorf("Node from web pool should be outside its reboot window")
+	}
+
+	if !insideRebootWindows(k.rebootWindowsFor(node("other")), saturday.Add(-time.Hour/2)) {
+		t.Errorf("Node without pool should use global reboot window")
+	}
+}
@@ -0,0 +1,246 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+// nodeSnapshot is a snapshot of all nodes in the cluster, taken from the node informer cache at the
+// beginning of each reconciliation, so steps of the reconciliation do not list nodes on their own.
+//
+// Nodes updated during the reconciliation are updated in the snapshot, so each step sees changes
+// made by the previous steps. They are also recorded as mutations of the cache, so the next
+// reconciliation sees them even before they are delivered by the informer.
+//
+// In dry run, nodes are only updated in the snapshot and the updates are reported instead.
+type nodeSnapshot struct {
+	nc        corev1client.NodeInterface
+	mutations cache.MutationCache
+	items     []corev1.Node
+	// Nodes as they are in the cluster. Unless in dry run, they share the backing array with items.
+	observed []corev1.Node
+	// Reporter of updates in dry run, nil if not in dry run.
+	dryRunReporter *dryRunReporter
+}
+
+// dryRunReporter reports updates of nodes made in dry run using logs and Events. As nothing is
+// written to the cluster, every reconciliation makes the same updates until the nodes change, so
+// updates reported in the previous reconciliation are not reported again.
+type dryRunReporter struct {
+	recorder record.EventRecorder
+	// Updates reported in the previous and in the current reconciliation, by node name and changes.
+	previous map[string]struct{}
+	current  map[string]struct{}
+}
+
+// newDryRunReporter creates dryRunReporter emitting Events using the given recorder.
+func newDryRunReporter(er record.EventRecorder) *dryRunReporter {
+	return &dryRunReporter{
+		recorder: er,
+		previous: map[string]struct{}{},
+		current:  map[string]struct{}{},
+	}
+}
+
+// start starts reporting updates of the next reconciliation.
+func (r *dryRunReporter) start() {
+	r.previous = r.current
+	r.current = map[string]struct{}{}
+}
+
+// report reports the given changes of the given node, unless they were reported in the previous
+// reconciliation.
+func (r *dryRunReporter) report(node *corev1.Node, changes []string) {
+	description := strings.Join(changes, ", ")
+	// Node names can not contain a slash.
+	key := node.Name + "/" + description
+
+	r.current[key] = struct{}{}
+
+	if _, ok := r.previous[key]; ok {
+		klog.V(4).Infof("Dry run: would still update node %q: %s", node.Name, description)
+
+		return
+	}
+
+	klog.Infof("Dry run: would update node %q: %s", node.Name, description)
+	r.recorder.Eventf(node, corev1.EventTypeNormal, "DryRun", "Would update node: %s", description)
+}
+
+// event logs the Event, which would be emitted for the given node, unless it was logged in the previous
+// reconciliation. The Event itself is not emitted, as it would report an update, which was not made.
+func (r *dryRunReporter) event(node *corev1.Node, eventType, reason, message string) {
+	// Node names can not contain a slash.
+	key := node.Name + "/" + reason + ": " + message
+
+	r.current[key] = struct{}{}
+
+	if _, ok := r.previous[key]; ok {
+		return
+	}
+
+	klog.Infof("Dry run: would emit %s Event %q for node %q: %s", eventType, reason, node.Name, message)
+}
+
+// nodeEventf emits an Event for the given node reporting an update of the node made by the operator.
+// In dry run, the Event is only reported using dryRunReporter.
+func (k *Kontroller) nodeEventf(node *corev1.Node, eventType, reason, messageFmt string, args ...interface{}) {
+	if k.dryRun {
+		k.dryRunReporter.event(node, eventType, reason, fmt.Sprintf(messageFmt, args...))
+
+		return
+	}
+
+	k.er.Eventf(node, eventType, reason, messageFmt, args...)
+}
+
+// newNodeSnapshot creates a snapshot of nodes in the given store, overlaid with recorded mutations.
+// Nodes are ordered by name, like when listed from the API server.
+func newNodeSnapshot(nc corev1client.NodeInterface, store cache.Store,
+	mutations cache.MutationCache) (*nodeSnapshot, error) {
+	items := []corev1.Node{}
+
+	for _, key := range store.ListKeys() {
+		obj, exists, err := mutations.GetByKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("getting node %q from cache: %w", key, err)
+		}
+
+		if !exists {
+			continue
+		}
+
+		node, ok := obj.(*corev1.Node)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object of type %T in node cache", obj)
+		}
+
+		items = append(items, snapshotNode(node))
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+
+	return &nodeSnapshot{
+		nc:        nc,
+		mutations: mutations,
+		items:     items,
+		observed:  items,
+	}, nil
+}
+
+// snapshotNode returns a copy of the given node from the cache, which can be safely modified.
+func snapshotNode(node *corev1.Node) corev1.Node {
+	n := node.DeepCopy()
+
+	if n.Labels == nil {
+		n.Labels = map[string]string{}
+	}
+
+	if n.Annotations == nil {
+		n.Annotations = map[string]string{}
+	}
+
+	return *n
+}
+
+// setDryRun makes the snapshot only update nodes in the snapshot and report the updates using
+// the given reporter, without updating nodes in the cluster.
+func (s *nodeSnapshot) setDryRun(r *dryRunReporter) {
+	s.dryRunReporter = r
+	s.observed = append([]corev1.Node{}, s.items...)
+}
+
+// update updates the node with the given name using the given function, both in the cluster and in
+// the snapshot.
+func (s *nodeSnapshot) update(name string, f func(*corev1.Node)) error {
+	if s.dryRunReporter != nil {
+		return s.updateDryRun(name, f)
+	}
+
+	node, err := k8sutil.UpdateNodeRetryResult(s.nc, name, f)
+	if err != nil {
+		return err //nolint:wrapcheck // Callers add the context.
+	}
+
+	s.mutations.Mutation(node)
+
+	for i := range s.items {
+		if s.items[i].Name == name {
+			s.items[i] = snapshotNode(node)
+		}
+	}
+
+	return nil
+}
+
+// updateDryRun updates the node with the given name using the given function only in the snapshot
+// and reports the changes.
+func (s *nodeSnapshot) updateDryRun(name string, f func(*corev1.Node)) error {
+	for i := range s.items {
+		if s.items[i].Name != name {
+			continue
+		}
+
+		node := s.items[i].DeepCopy()
+		f(node)
+
+		if changes := nodeChanges(s.items[i], *node); len(changes) > 0 {
+			s.dryRunReporter.report(node, changes)
+		}
+
+		s.items[i] = *node
+
+		return nil
+	}
+
+	return fmt.Errorf("node %q not found in snapshot", name)
+}
+
+// nodeChanges returns human readable list of changes of labels and annotations between the given
+// versions of the node.
+func nodeChanges(oldNode, newNode corev1.Node) []string {
+	changes := mapChanges("label", oldNode.Labels, newNode.Labels)
+
+	return append(changes, mapChanges("annotation", oldNode.Annotations, newNode.Annotations)...)
+}
+
+// mapChanges returns human readable list of changes between the given maps, ordered by key.
+func mapChanges(kind string, oldMap, newMap map[string]string) []string {
+	changes := []string{}
+
+	for k, v := range newMap {
+		if oldValue, ok := oldMap[k]; !ok || oldValue != v {
+			changes = append(changes, fmt.Sprintf("set %s %q to %q", kind, k, v))
+		}
+	}
+
+	for k := range oldMap {
+		if _, ok := newMap[k]; !ok {
+			changes = append(changes, fmt.Sprintf("remove %s %q", kind, k))
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes
+}
+
+// setAnnotations sets given annotations on the node with the given name.
+func (s *nodeSnapshot) setAnnotations(name string, annotations map[string]string) error {
+	return s.update(name, func(node *corev1.Node) {
+		for k, v := range annotations {
+			node.Annotations[k] = v
+		}
+	})
+}
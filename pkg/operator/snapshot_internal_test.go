@@ -0,0 +1,325 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+func testNode(name, resourceVersion string, annotations map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			ResourceVersion: resourceVersion,
+			Annotations:     annotations,
+		},
+	}
+}
+
+func Test_newNodeSnapshot(t *testing.T) {
+	t.Parallel()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	for _, node := range []*corev1.Node{
+		testNode("c", "1", nil),
+		testNode("a", "2", map[string]string{constants.AnnotationOkToReboot: constants.False}),
+		testNode("b", "3", nil),
+	} {
+		if err := indexer.Add(node); err != nil {
+			t.Fatalf("Adding node: %v", err)
+		}
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	// Newer version of the node written by the operator, not yet seen by the informer.
+	mutations.Mutation(testNode("a", "4", map[string]string{constants.AnnotationOkToReboot: constants.True}))
+
+	// Older version than the one in the store is ignored.
+	mutations.Mutation(testNode("b", "0", map[string]string{constants.AnnotationOkToReboot: constants.True}))
+
+	nodes, err := newNodeSnapshot(nil, indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	if len(nodes.items) != 3 {
+		t.Fatalf("Expected 3 nodes, got %d", len(nodes.items))
+	}
+
+	for i, name := range []string{"a", "b", "c"} {
+		if nodes.items[i].Name != name {
+			t.Fatalf("Expected node %q at position %d, got %q", name, i, nodes.items[i].Name)
+		}
+
+		if nodes.items[i].Labels == nil || nodes.items[i].Annotations == nil {
+			t.Fatalf("Expected labels and annotations of node %q to be initialized", name)
+		}
+	}
+
+	if nodes.items[0].Annotations[constants.AnnotationOkToReboot] != constants.True {
+		t.Fatalf("Expected mutation of node %q to be applied", nodes.items[0].Name)
+	}
+
+	if _, ok := nodes.items[1].Annotations[constants.AnnotationOkToReboot]; ok {
+		t.Fatalf("Expected outdated mutation of node %q to be ignored", nodes.items[1].Name)
+	}
+
+	nodes.items[2].Annotations["foo"] = "bar"
+
+	obj, _, err := indexer.GetByKey("c")
+	if err != nil {
+		t.Fatalf("Getting node: %v", err)
+	}
+
+	if node, _ := obj.(*corev1.Node); node.Annotations != nil {
+		t.Fatalf("Expected cached node to not be modified, got annotations %v", node.Annotations)
+	}
+}
+
+func Test_nodeSnapshot_update_in_dry_run_only_updates_snapshot(t *testing.T) {
+	t.Parallel()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	node := testNode("a", "1", map[string]string{constants.AnnotationOkToReboot: constants.False})
+
+	if err := indexer.Add(node); err != nil {
+		t.Fatalf("Adding node: %v", err)
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	// Node client is nil, so any attempt to update the node in the cluster would panic.
+	nodes, err := newNodeSnapshot(nil, indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	recorder := record.NewFakeRecorder(1)
+
+	nodes.setDryRun(newDryRunReporter(recorder))
+
+	err = nodes.update("a", func(node *corev1.Node) {
+		node.Labels[constants.LabelBeforeReboot] = constants.True
+		delete(node.Annotations, constants.AnnotationOkToReboot)
+	})
+	if err != nil {
+		t.Fatalf("Updating node: %v", err)
+	}
+
+	if nodes.items[0].Labels[constants.LabelBeforeReboot] != constants.True {
+		t.Fatalf("Expected node to be updated in the snapshot, got labels %v", nodes.items[0].Labels)
+	}
+
+	if _, ok := nodes.observed[0].Labels[constants.LabelBeforeReboot]; ok {
+		t.Fatalf("Expected observed node to not be updated, got labels %v", nodes.observed[0].Labels)
+	}
+
+	expected := `Normal DryRun Would update node: set label "flatcar-linux-update.v1.flatcar-linux.net/before-reboot" ` +
+		`to "true", remove annotation "flatcar-linux-update.v1.flatcar-linux.net/reboot-ok"`
+
+	if event := <-recorder.Events; event != expected {
+		t.Fatalf("Expected event %q, got %q", expected, event)
+	}
+
+	if _, exists, _ := mutations.GetByKey("a"); !exists {
+		t.Fatalf("Expected node to still exist in the cache")
+	}
+
+	if err := nodes.update("b", func(*corev1.Node) {}); err == nil {
+		t.Fatalf("Expected error updating node which is not in the snapshot")
+	}
+}
+
+func Test_dryRunReporter_reports_same_changes_only_once(t *testing.T) {
+	t.Parallel()
+
+	recorder := record.NewFakeRecorder(10)
+	r := newDryRunReporter(recorder)
+	node := testNode("a", "1", nil)
+
+	for _, changes := range [][]string{
+		{"set label \"a\" to \"true\""},
+		// Same changes in the next reconciliation are not reported.
+		{"set label \"a\" to \"true\""},
+		{"set label \"b\" to \"true\""},
+		// Changes not made in the previous reconciliation are reported again.
+		{"set label \"a\" to \"true\""},
+	} {
+		r.start()
+		r.report(node, changes)
+	}
+
+	if n := len(recorder.Events); n != 3 {
+		t.Fatalf("Expected 3 events, got %d", n)
+	}
+}
+
+func Test_nodeChanged(t *testing.T) {
+	t.Parallel()
+
+	base := func() *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:      map[string]string{constants.LabelID: "flatcar"},
+				Annotations: map[string]string{constants.AnnotationRebootNeeded: constants.False},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		modify   func(*corev1.Node)
+		expected bool
+	}{
+		"heartbeat_is_ignored": {
+			modify: func(node *corev1.Node) {
+				node.ResourceVersion = "2"
+				node.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+			},
+		},
+		"annotation_change_is_detected": {
+			modify: func(node *corev1.Node) {
+				node.Annotations[constants.AnnotationRebootNeeded] = constants.True
+			},
+			expected: true,
+		},
+		"label_change_is_detected": {
+			modify: func(node *corev1.Node) {
+				node.Labels[constants.LabelBeforeReboot] = constants.True
+			},
+			expected: true,
+		},
+		"readiness_change_is_detected": {
+			modify: func(node *corev1.Node) {
+				node.Status.Conditions[0].Status = corev1.ConditionFalse
+			},
+			expected: true,
+		},
+		"cordoning_is_detected": {
+			modify: func(node *corev1.Node) {
+				node.Spec.Unschedulable = true
+			},
+			expected: true,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			newNode := base()
+			c.modify(newNode)
+
+			if changed := nodeChanged(base(), newNode); changed != c.expected {
+				t.Fatalf("Expected changed to be %v, got %v", c.expected, changed)
+			}
+		})
+	}
+}
+
+//nolint:funlen // Just many nodes to set up.
+func Test_Kontroller_in_dry_run_does_not_emit_Warning_Events_nor_call_webhooks(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	aborted := testNode("aborted", "1", map[string]string{
+		constants.AnnotationOkToReboot:       constants.True,
+		constants.AnnotationRebootNeeded:     constants.False,
+		constants.AnnotationRebootInProgress: constants.False,
+		constants.AnnotationRebootAborted:    constants.True,
+	})
+
+	failed := testNode("failed", "1", map[string]string{
+		constants.AnnotationOkToReboot:           constants.True,
+		constants.AnnotationOkToRebootTime:       now.Add(-3 * time.Hour).UTC().Format(time.RFC3339),
+		constants.AnnotationRebootNeeded:         constants.True,
+		constants.AnnotationRebootInProgress:     constants.True,
+		constants.AnnotationRebootInProgressTime: now.Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+	})
+
+	before := testNode("before", "1", map[string]string{})
+	before.Labels = map[string]string{constants.LabelBeforeReboot: constants.True}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	for _, node := range []*corev1.Node{aborted, failed, before} {
+		if err := indexer.Add(node); err != nil {
+			t.Fatalf("Adding node: %v", err)
+		}
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	// Node client is nil, so any attempt to update the node in the cluster would panic.
+	nodes, err := newNodeSnapshot(nil, indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Webhook should not be called in dry run")
+		fmt.Fprint(w, `{"decision": "deny"}`)
+	}))
+	defer server.Close()
+
+	webhook, err := newRebootWebhook(rebootPhaseBefore, server.URL, 0)
+	if err != nil {
+		t.Fatalf("Creating webhook: %v", err)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	k := &Kontroller{
+		er:             recorder,
+		dryRun:         true,
+		dryRunReporter: newDryRunReporter(recorder),
+		rebootDeadline: time.Hour,
+		rebootWebhooks: []*rebootWebhook{webhook},
+	}
+
+	k.dryRunReporter.start()
+	nodes.setDryRun(k.dryRunReporter)
+
+	if err := k.releaseAbortedReboots(nodes); err != nil {
+		t.Fatalf("Releasing aborted reboots: %v", err)
+	}
+
+	if err := k.detectRebootFailures(nodes); err != nil {
+		t.Fatalf("Detecting reboot failures: %v", err)
+	}
+
+	if err := k.runRebootWebhooks(context.Background(), nodes); err != nil {
+		t.Fatalf("Running reboot webhooks: %v", err)
+	}
+
+	if err := k.abortPhase(nodes, *before, k.phases()[0], "timed out", now); err != nil {
+		t.Fatalf("Aborting phase: %v", err)
+	}
+
+	close(recorder.Events)
+
+	for event := range recorder.Events {
+		if strings.HasPrefix(event, corev1.EventTypeWarning) {
+			t.Errorf("Unexpected Warning Event in dry run: %q", event)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package operator
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// topologyBudget keeps track of remaining reboot slots in each topology domain,
+// e.g. in each availability zone when the topology key is 'topology.kubernetes.io/zone'.
+//
+// Nodes which do not have the topology key label are all considered to be part of
+// a single domain.
+type topologyBudget struct {
+	key       string
+	remaining map[string]int
+}
+
+// newTopologyBudget creates topologyBudget for the given topology key. Limit for each domain is
+// calculated from the number of managed nodes in the domain and then lowered by the number
+// of rebooting nodes from the same domain.
+//
+// If topology key is empty, returned budget does not limit nodes at all.
+func newTopologyBudget(key string, maxUnavailable intstr.IntOrString,
+	managed, rebooting []corev1.Node) *topologyBudget {
+	if key == "" {
+		return &topologyBudget{}
+	}
+
+	domainSizes := map[string]int{}
+
+	for _, node := range managed {
+		domainSizes[node.Labels[key]]++
+	}
+
+	remaining := map[string]int{}
+
+	for domain, size := range domainSizes {
+		remaining[domain] = maxUnavailableNodes(maxUnavailable, size)
+	}
+
+	for _, node := range rebooting {
+		remaining[node.Labels[key]]--
+	}
+
+	return &topologyBudget{
+		key:       key,
+		remaining: remaining,
+	}
+}
+
+// domain returns the topology domain of the given node.
+func (b *topologyBudget) domain(node corev1.Node) string {
+	return node.Labels[b.key]
+}
+
+// take claims a reboot slot for the given node in its topology domain. If there are no
+// slots left in this domain, false is returned.
+func (b *topologyBudget) take(node corev1.Node) bool {
+	if b.key == "" {
+		return true
+	}
+
+	domain := b.domain(node)
+
+	if b.remaining[domain] < 1 {
+		return false
+	}
+
+	b.remaining[domain]--
+
+	return true
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func Test_topologyBudget_limits_rebooting_nodes_per_domain(t *testing.T) {
+	t.Parallel()
+
+	key := "topology.kubernetes.io/zone"
+
+	node := func(name, zone string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{key: zone}}}
+	}
+
+	managed := []corev1.Node{
+		node("a1", "a"), node("a2", "a"),
+		node("b1", "b"), node("b2", "b"),
+		node("c1", "c"), node("c2", "c"),
+	}
+
+	budget := newTopologyBudget(key, intstr.FromInt(1), managed, []corev1.Node{node("a1", "a")})
+
+	if budget.take(node("a2", "a")) {
+		t.Errorf("Node from domain with already rebooting node should not get a slot")
+	}
+
+	if !budget.take(node("b1", "b")) {
+		t.Errorf("Node from domain without rebooting nodes should get a slot")
+	}
+
+	if budget.take(node("b2", "b")) {
+		t.Errorf("Second node from the same domain should not get a slot")
+	}
+
+	if !budget.take(node("c1", "c")) {
+		t.Errorf("Node from another domain should get a slot")
+	}
+}
+
+func Test_topologyBudget_does_not_limit_nodes_without_topology_key(t *testing.T) {
+	t.Parallel()
+
+	budget := newTopologyBudget("", intstr.FromInt(1), nil, nil)
+
+	for i := 0; i < 3; i++ {
+		if !budget.take(corev1.Node{}) {
+			t.Fatalf("Budget without topology key should not limit nodes")
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+)
+
+// updatePolicyResource is the UpdatePolicy resource watched by the operator.
+var updatePolicyResource = updatev1alpha1.SchemeGroupVersion.WithResource(updatev1alpha1.UpdatePolicyResource)
+
+// newUpdatePolicyInformer creates informer watching only the UpdatePolicy with the given name.
+func newUpdatePolicyInformer(dc dynamic.Interface, name string) cache.SharedIndexInformer {
+	return dynamicinformer.NewFilteredDynamicInformer(dc, updatePolicyResource, metav1.NamespaceAll, 0,
+		cache.Indexers{}, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}).Informer()
+}
+
+// syncUpdatePolicy applies configuration from the UpdatePolicy, if it has changed since the last
+// reconciliation, and reports the applied configuration or the error in the policy status.
+//
+// If the policy is not valid, the operator keeps running with the previously applied configuration.
+// If the policy is removed, the operator reverts to the configuration given on creation.
+func (k *Kontroller) syncUpdatePolicy(ctx context.Context) error {
+	if k.updatePolicy == "" {
+		return nil
+	}
+
+	policy, err := k.getUpdatePolicy()
+	if err != nil {
+		return fmt.Errorf("getting UpdatePolicy %q: %w", k.updatePolicy, err)
+	}
+
+	if policy == nil {
+		if k.updatePolicyUID == "" {
+			return nil
+		}
+
+		klog.Infof("UpdatePolicy %q has been removed, reverting to the default configuration", k.updatePolicy)
+
+		if err := k.configure(k.config); err != nil {
+			return fmt.Errorf("applying default configuration: %w", err)
+		}
+
+		k.updatePolicyUID = ""
+		k.updatePolicyGeneration = 0
+
+		return nil
+	}
+
+	if policy.UID == k.updatePolicyUID && policy.Generation == k.updatePolicyGeneration {
+		return nil
+	}
+
+	config := applyUpdatePolicy(k.config, policy.Spec)
+
+	policy.Status = updatev1alpha1.UpdatePolicyStatus{
+		ObservedGeneration: policy.Generation,
+	}
+
+	if err := k.configure(config); err != nil {
+		klog.Errorf("Failed to apply UpdatePolicy %q, keeping previous configuration: %v", policy.Name, err)
+
+		policy.Status.Error = err.Error()
+	} else {
+		klog.Infof("Applied UpdatePolicy %q generation %d", policy.Name, policy.Generation)
+
+		policy.Status.ObservedConfiguration = k.observedConfiguration(config)
+	}
+
+	if err := k.updateUpdatePolicyStatus(ctx, policy); err != nil {
+		return fmt.Errorf("updating status of UpdatePolicy %q: %w", policy.Name, err)
+	}
+
+	k.updatePolicyUID = policy.UID
+	k.updatePolicyGeneration = policy.Generation
+
+	return nil
+}
+
+// getUpdatePolicy gets the configured UpdatePolicy from the informer cache. If the policy
+// does not exist, nil is returned.
+func (k *Kontroller) getUpdatePolicy() (*updatev1alpha1.UpdatePolicy, error) {
+	obj, exists, err := k.updatePolicyInformer.GetStore().GetByKey(k.updatePolicy)
+	if err != nil {
+		return nil, fmt.Errorf("getting object from cache: %w", err)
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T in cache", obj)
+	}
+
+	policy := &updatev1alpha1.UpdatePolicy{}
+
+	// Converted policy does not share any data with the cached object, so it can be modified.
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+		return nil, fmt.Errorf("converting object: %w", err)
+	}
+
+	return policy, nil
+}
+
+// updateUpdatePolicyStatus updates status of the given UpdatePolicy.
+func (k *Kontroller) updateUpdatePolicyStatus(ctx context.Context, policy *updatev1alpha1.UpdatePolicy) error {
+	policy.APIVersion = updatev1alpha1.SchemeGroupVersion.String()
+	policy.Kind = "UpdatePolicy"
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return fmt.Errorf("converting object: %w", err)
+	}
+
+	_, err = k.dc.Resource(updatePolicyResource).UpdateStatus(ctx, &unstructured.Unstructured{Object: content},
+		metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("updating object: %w", err)
+	}
+
+	return nil
+}
+
+// updatePolicyEventHandler queues the reconciliation on every change of the UpdatePolicy,
+// so the changed configuration is applied right away.
+func (k *Kontroller) updatePolicyEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			k.enqueue()
+		},
+		UpdateFunc: func(interface{}, interface{}) {
+			k.enqueue()
+		},
+		DeleteFunc: func(interface{}) {
+			k.enqueue()
+		},
+	}
+}
+
+// applyUpdatePolicy returns the given configuration with fields set in the UpdatePolicy spec replaced.
+func applyUpdatePolicy(config Config, spec updatev1alpha1.UpdatePolicySpec) Config {
+	if spec.RebootWindows != nil {
+		// Policy replaces all global reboot windows, including the legacy one.
+		config.RebootWindowStart = ""
+		config.RebootWindowLength = ""
+		config.RebootWindows = spec.RebootWindows
+	}
+
+	if spec.PoolRebootWindows != nil {
+		config.PoolRebootWindows = []PoolRebootWindow{}
+
+		for _, pool := range spec.PoolRebootWindows {
+			config.PoolRebootWindows = append(config.PoolRebootWindows, PoolRebootWindow{
+				Selector: pool.Selector,
+				Windows:  pool.Windows,
+			})
+		}
+	}
+
+	if spec.MaxUnavailable != nil {
+		config.MaxUnavailable = spec.MaxUnavailable.String()
+	}
+
+	if spec.TopologyKey != nil {
+		config.TopologyKey = *spec.TopologyKey
+	}
+
+	if spec.MaxUnavailablePerTopology != nil {
+		config.MaxUnavailablePerTopology = spec.MaxUnavailablePerTopology.String()
+	}
+
+	if spec.BeforeRebootAnnotations != nil {
+		config.BeforeRebootAnnotations = spec.BeforeRebootAnnotations
+	}
+
+	if spec.AfterRebootAnnotations != nil {
+		config.AfterRebootAnnotations = spec.AfterRebootAnnotations
+	}
+
+	if spec.CanarySelector != nil {
+		config.CanarySelector = *spec.CanarySelector
+	}
+
+	if spec.ControlPlaneSelector != nil {
+		config.ControlPlaneSelector = *spec.ControlPlaneSelector
+	}
+
+	if spec.ControlPlaneRebootWindows != nil {
+		config.ControlPlaneRebootWindows = spec.ControlPlaneRebootWindows
+	}
+
+	return config
+}
+
+// observedConfiguration returns the given applied configuration in the form of UpdatePolicy spec.
+func (k *Kontroller) observedConfiguration(config Config) *updatev1alpha1.UpdatePolicySpec {
+	pools := []updatev1alpha1.PoolRebootWindows{}
+
+	for _, pool := range config.PoolRebootWindows {
+		pools = append(pools, updatev1alpha1.PoolRebootWindows{
+			Selector: pool.Selector,
+			Windows:  pool.Windows,
+		})
+	}
+
+	maxUnavailable := k.maxUnavailable
+	maxUnavailablePerTopology := k.maxUnavailablePerTopology
+	controlPlaneSelector := k.controlPlaneSelector.String()
+
+	return &updatev1alpha1.UpdatePolicySpec{
+		RebootWindows:             config.RebootWindows,
+		PoolRebootWindows:         pools,
+		MaxUnavailable:            &maxUnavailable,
+		TopologyKey:               &config.TopologyKey,
+		MaxUnavailablePerTopology: &maxUnavailablePerTopology,
+		// Annotations set by reboot Jobs and webhooks are included.
+		BeforeRebootAnnotations:   k.beforeRebootAnnotations,
+		AfterRebootAnnotations:    k.afterRebootAnnotations,
+		CanarySelector:            &config.CanarySelector,
+		ControlPlaneSelector:      &controlPlaneSelector,
+		ControlPlaneRebootWindows: config.ControlPlaneRebootWindows,
+	}
+}
@@ -0,0 +1,162 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+
+	updatev1alpha1 "github.com/kinvolk/flatcar-linux-update-operator/pkg/apis/update/v1alpha1"
+)
+
+func Test_applyUpdatePolicy(t *testing.T) {
+	t.Parallel()
+
+	base := Config{
+		RebootWindowStart:       "Mon 14:00",
+		RebootWindowLength:      "1h",
+		RebootWindows:           []string{"22:00 4h UTC"},
+		MaxUnavailable:          "1",
+		CanarySelector:          "canary=true",
+		BeforeRebootAnnotations: []string{"before"},
+	}
+
+	cases := map[string]struct {
+		spec     string
+		expected Config
+	}{
+		"keeps_configuration_when_policy_is_empty": {
+			spec:     `{}`,
+			expected: base,
+		},
+		"replaces_all_global_reboot_windows": {
+			spec: `{"rebootWindows": ["Sat 02:00 6h UTC"]}`,
+			expected: Config{
+				RebootWindows:           []string{"Sat 02:00 6h UTC"},
+				MaxUnavailable:          "1",
+				CanarySelector:          "canary=true",
+				BeforeRebootAnnotations: []string{"before"},
+			},
+		},
+		"clears_fields_set_to_empty_values": {
+			spec: `{"canarySelector": "", "beforeRebootAnnotations": []}`,
+			expected: Config{
+				RebootWindowStart:       "Mon 14:00",
+				RebootWindowLength:      "1h",
+				RebootWindows:           []string{"22:00 4h UTC"},
+				MaxUnavailable:          "1",
+				BeforeRebootAnnotations: []string{},
+			},
+		},
+		"accepts_concurrency_as_number_or_percentage": {
+			spec: `{"maxUnavailable": 3, "maxUnavailablePerTopology": "10%"}`,
+			expected: Config{
+				RebootWindowStart:         "Mon 14:00",
+				RebootWindowLength:        "1h",
+				RebootWindows:             []string{"22:00 4h UTC"},
+				MaxUnavailable:            "3",
+				MaxUnavailablePerTopology: "10%",
+				CanarySelector:            "canary=true",
+				BeforeRebootAnnotations:   []string{"before"},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			spec := updatev1alpha1.UpdatePolicySpec{}
+
+			if err := json.Unmarshal([]byte(c.spec), &spec); err != nil {
+				t.Fatalf("Decoding spec: %v", err)
+			}
+
+			if config := applyUpdatePolicy(base, spec); !reflect.DeepEqual(config, c.expected) {
+				t.Fatalf("Expected configuration %+v, got %+v", c.expected, config)
+			}
+		})
+	}
+}
+
+func Test_configure_keeps_previous_configuration_on_error(t *testing.T) {
+	t.Parallel()
+
+	k := &Kontroller{}
+
+	if err := k.configure(Config{MaxUnavailable: "2", RebootWindows: []string{"22:00 4h UTC"}}); err != nil {
+		t.Fatalf("Configuring: %v", err)
+	}
+
+	if err := k.configure(Config{MaxUnavailable: "3", RebootWindows: []string{"invalid"}}); err == nil {
+		t.Fatalf("Expected error configuring invalid reboot window")
+	}
+
+	if k.maxUnavailable.IntValue() != 2 || len(k.rebootWindows) != 1 {
+		t.Fatalf("Expected previous configuration to be kept, got max unavailable %s and %d reboot windows",
+			k.maxUnavailable.String(), len(k.rebootWindows))
+	}
+}
+
+func Test_syncUpdatePolicy_applies_policy_from_informer_and_reports_status(t *testing.T) {
+	t.Parallel()
+
+	policy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": updatev1alpha1.SchemeGroupVersion.String(),
+		"kind":       "UpdatePolicy",
+		"metadata":   map[string]interface{}{"name": "default", "generation": int64(2)},
+		"spec":       map[string]interface{}{"maxUnavailable": int64(3)},
+	}}
+
+	dc := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), policy)
+
+	k := &Kontroller{
+		dc:                   dc,
+		config:               Config{MaxUnavailable: "1"},
+		updatePolicy:         "default",
+		updatePolicyInformer: newUpdatePolicyInformer(dc, "default"),
+	}
+
+	if err := k.configure(k.config); err != nil {
+		t.Fatalf("Configuring: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go k.updatePolicyInformer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), k.updatePolicyInformer.HasSynced) {
+		t.Fatalf("Waiting for informer to sync")
+	}
+
+	if err := k.syncUpdatePolicy(ctx); err != nil {
+		t.Fatalf("Syncing UpdatePolicy: %v", err)
+	}
+
+	if k.maxUnavailable.IntValue() != 3 {
+		t.Fatalf("Expected max unavailable from policy to be applied, got %s", k.maxUnavailable.String())
+	}
+
+	updated, err := dc.Resource(updatePolicyResource).Get(ctx, "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Getting UpdatePolicy: %v", err)
+	}
+
+	generation, _, _ := unstructured.NestedInt64(updated.Object, "status", "observedGeneration")
+	if generation != 2 {
+		t.Fatalf("Expected observed generation 2 in policy status, got %d", generation)
+	}
+
+	if _, found, _ := unstructured.NestedMap(updated.Object, "status", "observedConfiguration"); !found {
+		t.Fatalf("Expected observed configuration in policy status, got %v", updated.Object["status"])
+	}
+}
@@ -0,0 +1,322 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/k8sutil"
+)
+
+const (
+	// Decisions accepted in webhook responses.
+	webhookDecisionAllow = "allow"
+	webhookDecisionDeny  = "deny"
+	webhookDecisionRetry = "retry"
+
+	// defaultWebhookTimeout is a default timeout for webhook requests.
+	defaultWebhookTimeout = 10 * time.Second
+
+	// webhookMaxConcurrentCalls is the maximum number of nodes for which the webhook is called at once.
+	webhookMaxConcurrentCalls = 10
+
+	// Initial and maximum delay before the webhook is called again for a node, when it asked to retry
+	// later or when the call failed. The delay grows exponentially with each retry.
+	webhookRetryInitialDelay = 5 * time.Second
+	webhookRetryMaxDelay     = 5 * time.Minute
+)
+
+// webhookRequest is a JSON payload sent to the webhook.
+type webhookRequest struct {
+	// Name of the node.
+	Node string `json:"node"`
+	// Phase of the reboot, either "before-reboot" or "after-reboot".
+	Phase       string            `json:"phase"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// webhookResponse is a JSON payload expected from the webhook.
+type webhookResponse struct {
+	// Decision is either "allow", "deny" or "retry".
+	Decision string `json:"decision"`
+	// Optional human readable reason of the decision.
+	Reason string `json:"reason,omitempty"`
+}
+
+// rebootWebhook calls an HTTP endpoint for each node in the before-reboot or after-reboot phase
+// and sets the phase annotation on the node based on the answer.
+type rebootWebhook struct {
+	phase      string
+	url        string
+	req        *labels.Requirement
+	annotation string
+	client     *http.Client
+
+	// Delays of retries for nodes, for which the webhook asked to retry later or could not be called,
+	// and the time when the webhook may be called again for them.
+	retries   workqueue.RateLimiter
+	nextCalls map[string]time.Time
+}
+
+// webhookCall is the result of calling the webhook for a single node.
+type webhookCall struct {
+	node     corev1.Node
+	response *webhookResponse
+	err      error
+}
+
+// newRebootWebhook creates rebootWebhook for the given phase calling the given URL. If URL is
+// empty, nil is returned.
+func newRebootWebhook(phase, webhookURL string, timeout time.Duration) (*rebootWebhook, error) {
+	if webhookURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing URL %q: %w", webhookURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("URL %q must use http or https scheme", webhookURL)
+	}
+
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	w := &rebootWebhook{
+		phase:  phase,
+		url:    webhookURL,
+		client: &http.Client{Timeout: timeout},
+		retries: workqueue.NewItemExponentialFailureRateLimiter(webhookRetryInitialDelay,
+			webhookRetryMaxDelay),
+		nextCalls: map[string]time.Time{},
+	}
+
+	switch phase {
+	case rebootPhaseBefore:
+		w.req = beforeRebootReq
+		w.annotation = constants.AnnotationBeforeRebootWebhook
+	case rebootPhaseAfter:
+		w.req = afterRebootReq
+		w.annotation = constants.AnnotationAfterRebootWebhook
+	}
+
+	return w, nil
+}
+
+// call sends the node to the webhook and returns its response.
+func (w *rebootWebhook) call(ctx context.Context, node corev1.Node) (*webhookResponse, error) {
+	body, err := json.Marshal(webhookRequest{
+		Node:        node.Name,
+		Phase:       w.phase,
+		Labels:      node.Labels,
+		Annotations: node.Annotations,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	defer resp.Body.Close() //nolint:errcheck // Nothing to do on error.
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, respBody)
+	}
+
+	r := &webhookResponse{}
+
+	if err := json.Unmarshal(respBody, r); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	switch r.Decision {
+	case webhookDecisionAllow, webhookDecisionDeny, webhookDecisionRetry:
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unknown decision %q, expected one of: %q, %q, %q", r.Decision,
+			webhookDecisionAllow, webhookDecisionDeny, webhookDecisionRetry)
+	}
+}
+
+// pendingNodes returns nodes in the phase of the webhook, which do not have the phase annotation
+// set to "true" yet and whose retry delay has passed at the given time. Retry delays of nodes which
+// are no longer pending are forgotten.
+func (w *rebootWebhook) pendingNodes(nodes []corev1.Node, now time.Time) []corev1.Node {
+	pending := []corev1.Node{}
+	waiting := map[string]struct{}{}
+
+	for _, node := range k8sutil.FilterNodesByRequirement(nodes, w.req) {
+		if node.Annotations[w.annotation] == constants.True ||
+			node.Annotations[constants.AnnotationRebootFailed] == constants.True {
+			continue
+		}
+
+		waiting[node.Name] = struct{}{}
+
+		if next, ok := w.nextCalls[node.Name]; ok && now.Before(next) {
+			klog.V(4).Infof("Not calling %s webhook for node %q until %s", w.phase, node.Name, next.Format(time.RFC3339))
+
+			continue
+		}
+
+		pending = append(pending, node)
+	}
+
+	for name := range w.nextCalls {
+		if _, ok := waiting[name]; !ok {
+			w.forget(name)
+		}
+	}
+
+	return pending
+}
+
+// retryLater delays the next call of the webhook for the given node.
+func (w *rebootWebhook) retryLater(name string, now time.Time) {
+	w.nextCalls[name] = now.Add(w.retries.When(name))
+}
+
+// forget resets the retry delay of the given node.
+func (w *rebootWebhook) forget(name string) {
+	w.retries.Forget(name)
+	delete(w.nextCalls, name)
+}
+
+// callAll calls the webhook for all given nodes, at most webhookMaxConcurrentCalls at once, and
+// returns results in the order of the nodes. Calls are cancelled when the given context is cancelled.
+func (w *rebootWebhook) callAll(ctx context.Context, nodes []corev1.Node) []webhookCall {
+	calls := make([]webhookCall, len(nodes))
+	slots := make(chan struct{}, webhookMaxConcurrentCalls)
+
+	var wg sync.WaitGroup
+
+	for i, node := range nodes {
+		i, node := i, node
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			r, err := w.call(ctx, node)
+			calls[i] = webhookCall{node: node, response: r, err: err}
+		}()
+	}
+
+	wg.Wait()
+
+	return calls
+}
+
+// runRebootWebhooks calls configured before-reboot and after-reboot webhooks for nodes in the
+// matching phase, which do not have the phase annotation set to "true" yet. The webhook is called
+// for multiple nodes concurrently and calls are cancelled when the given context is cancelled.
+//
+// When the webhook allows the node to proceed, the phase annotation is set to "true". When it
+// denies, the annotation is set to "false" and an Event is emitted. When it asks to retry later,
+// nothing is changed and the webhook is called again in a later reconciliation, with exponentially
+// growing delay, so the webhook is not called on every node event.
+//
+// Errors calling the webhook are logged and retried the same way, so a single failing node does not
+// block other nodes. If there is an error updating any of the nodes, an error is immediately returned.
+//
+// In dry run, webhooks are not called, as they may act on the call, e.g. by preparing the node for
+// reboot. Nodes for which they would be called are only logged.
+func (k *Kontroller) runRebootWebhooks(ctx context.Context, nodes *nodeSnapshot) error {
+	now := time.Now()
+
+	for _, w := range k.rebootWebhooks {
+		if k.dryRun {
+			for _, node := range w.pendingNodes(nodes.items, now) {
+				klog.Infof("Dry run: would call %s webhook for node %q", w.phase, node.Name)
+			}
+
+			continue
+		}
+
+		for _, c := range w.callAll(ctx, w.pendingNodes(nodes.items, now)) {
+			if err := k.handleWebhookCall(nodes, w, c, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// handleWebhookCall sets the phase annotation of the node based on the answer of the webhook.
+func (k *Kontroller) handleWebhookCall(nodes *nodeSnapshot, w *rebootWebhook, c webhookCall, now time.Time) error {
+	node := c.node
+
+	if c.err != nil {
+		klog.Errorf("Failed calling %s webhook for node %q: %v", w.phase, node.Name, c.err)
+		w.retryLater(node.Name, now)
+
+		return nil
+	}
+
+	r := c.response
+
+	klog.V(4).Infof("The %s webhook answered %q for node %q: %s", w.phase, r.Decision, node.Name, r.Reason)
+
+	value := ""
+
+	switch r.Decision {
+	case webhookDecisionAllow:
+		value = constants.True
+	case webhookDecisionDeny:
+		value = constants.False
+
+		if node.Annotations[w.annotation] != constants.False {
+			klog.Infof("The %s webhook denied node %q: %s", w.phase, node.Name, r.Reason)
+			k.er.Eventf(&node, corev1.EventTypeWarning, "RebootDenied", "The %s webhook denied the node: %s",
+				w.phase, r.Reason)
+		}
+	default:
+		w.retryLater(node.Name, now)
+
+		return nil
+	}
+
+	w.forget(node.Name)
+
+	if err := nodes.setAnnotations(node.Name, map[string]string{w.annotation: value}); err != nil {
+		return fmt.Errorf("setting annotations on node %q: %w", node.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/kinvolk/flatcar-linux-update-operator/pkg/constants"
+)
+
+//nolint:funlen // Just many test cases.
+func Test_rebootWebhook_call(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		status   int
+		response string
+		decision string
+		err      bool
+	}{
+		"allow": {
+			status:   http.StatusOK,
+			response: `{"decision": "allow"}`,
+			decision: webhookDecisionAllow,
+		},
+		"deny": {
+			status:   http.StatusOK,
+			response: `{"decision": "deny", "reason": "change freeze"}`,
+			decision: webhookDecisionDeny,
+		},
+		"retry": {
+			status:   http.StatusOK,
+			response: `{"decision": "retry"}`,
+			decision: webhookDecisionRetry,
+		},
+		"unknown_decision": {
+			status:   http.StatusOK,
+			response: `{"decision": "maybe"}`,
+			err:      true,
+		},
+		"server_error": {
+			status:   http.StatusInternalServerError,
+			response: `{"decision": "allow"}`,
+			err:      true,
+		},
+		"invalid_response": {
+			status:   http.StatusOK,
+			response: `allow`,
+			err:      true,
+		},
+	}
+
+	for name, c := range cases {
+		name, c := name, c
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var received webhookRequest
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Errorf("Decoding request: %v", err)
+				}
+
+				w.WriteHeader(c.status)
+				fmt.Fprint(w, c.response)
+			}))
+			defer server.Close()
+
+			w, err := newRebootWebhook(rebootPhaseBefore, server.URL, 0)
+			if err != nil {
+				t.Fatalf("Creating webhook: %v", err)
+			}
+
+			node := corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{"pool": "web"}},
+			}
+
+			r, err := w.call(context.Background(), node)
+
+			if received.Node != "foo" || received.Phase != rebootPhaseBefore || received.Labels["pool"] != "web" {
+				t.Errorf("Unexpected request payload: %+v", received)
+			}
+
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected error, got decision %q", r.Decision)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if r.Decision != c.decision {
+				t.Fatalf("Expected decision %q, got %q", c.decision, r.Decision)
+			}
+		})
+	}
+}
+
+func Test_newRebootWebhook_rejects_non_http_urls(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newRebootWebhook(rebootPhaseBefore, "ftp://example.com", 0); err == nil {
+		t.Fatalf("Expected error for non-HTTP URL")
+	}
+
+	w, err := newRebootWebhook(rebootPhaseBefore, "", 0)
+	if err != nil || w != nil {
+		t.Fatalf("Expected no webhook for empty URL, got %v, %v", w, err)
+	}
+}
+
+func webhookTestNode(name string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{constants.LabelBeforeReboot: constants.True},
+			Annotations: map[string]string{},
+		},
+	}
+}
+
+func Test_rebootWebhook_callAll_calls_webhook_for_nodes_concurrently(t *testing.T) {
+	t.Parallel()
+
+	nodes := []corev1.Node{webhookTestNode("a"), webhookTestNode("b"), webhookTestNode("c")}
+
+	var wg sync.WaitGroup
+
+	wg.Add(len(nodes))
+
+	// Each request is only answered once all requests have been received.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Done()
+		wg.Wait()
+
+		fmt.Fprint(w, `{"decision": "allow"}`)
+	}))
+	defer server.Close()
+
+	w, err := newRebootWebhook(rebootPhaseBefore, server.URL, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Creating webhook: %v", err)
+	}
+
+	for i, c := range w.callAll(context.Background(), nodes) {
+		if c.node.Name != nodes[i].Name {
+			t.Errorf("Expected result for node %q at position %d, got %q", nodes[i].Name, i, c.node.Name)
+		}
+
+		if c.err != nil {
+			t.Fatalf("Calling webhook for node %q: %v", c.node.Name, c.err)
+		}
+	}
+}
+
+func Test_rebootWebhook_callAll_stops_calls_when_context_is_cancelled(t *testing.T) {
+	t.Parallel()
+
+	// Webhook never answers, until the test finishes.
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	w, err := newRebootWebhook(rebootPhaseBefore, server.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("Creating webhook: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if c := w.callAll(ctx, []corev1.Node{webhookTestNode("a")}); c[0].err == nil {
+		t.Fatalf("Expected call to fail when context is cancelled")
+	}
+}
+
+func Test_runRebootWebhooks_delays_retries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		calls    int
+		decision = webhookDecisionRetry
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+
+		fmt.Fprintf(w, `{"decision": %q}`, decision)
+	}))
+	defer server.Close()
+
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return calls
+	}
+
+	webhook, err := newRebootWebhook(rebootPhaseBefore, server.URL, 0)
+	if err != nil {
+		t.Fatalf("Creating webhook: %v", err)
+	}
+
+	node := webhookTestNode("a")
+	kc := fake.NewSimpleClientset(&node)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	if err := indexer.Add(&node); err != nil {
+		t.Fatalf("Adding node: %v", err)
+	}
+
+	mutations := cache.NewIntegerResourceVersionMutationCache(klog.Background(), indexer, indexer, time.Minute, false)
+
+	nodes, err := newNodeSnapshot(kc.CoreV1().Nodes(), indexer, mutations)
+	if err != nil {
+		t.Fatalf("Creating snapshot: %v", err)
+	}
+
+	k := &Kontroller{er: record.NewFakeRecorder(1), rebootWebhooks: []*rebootWebhook{webhook}}
+
+	for i := 0; i < 2; i++ {
+		if err := k.runRebootWebhooks(context.Background(), nodes); err != nil {
+			t.Fatalf("Running webhooks: %v", err)
+		}
+	}
+
+	if n := callCount(); n != 1 {
+		t.Fatalf("Expected webhook to not be called again before retry delay passes, got %d calls", n)
+	}
+
+	// Pretend the retry delay has passed.
+	webhook.nextCalls[node.Name] = time.Now().Add(-time.Second)
+
+	mu.Lock()
+	decision = webhookDecisionAllow
+	mu.Unlock()
+
+	if err := k.runRebootWebhooks(context.Background(), nodes); err != nil {
+		t.Fatalf("Running webhooks: %v", err)
+	}
+
+	if n := callCount(); n != 2 {
+		t.Fatalf("Expected webhook to be called again after retry delay, got %d calls", n)
+	}
+
+	if nodes.items[0].Annotations[constants.AnnotationBeforeRebootWebhook] != constants.True {
+		t.Fatalf("Expected webhook annotation to be set, got annotations %v", nodes.items[0].Annotations)
+	}
+
+	if _, ok := webhook.nextCalls[node.Name]; ok {
+		t.Fatalf("Expected retry delay to be forgotten after the webhook answered")
+	}
+}
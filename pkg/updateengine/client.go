@@ -94,6 +94,16 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// Ping checks if update_engine is reachable over the D-Bus connection.
+func (c *Client) Ping() error {
+	call := c.object.Call("org.freedesktop.DBus.Peer.Ping", 0)
+	if call.Err != nil {
+		return call.Err
+	}
+
+	return nil
+}
+
 // ReceiveStatuses receives signal messages from dbus and sends them as Statues
 // on the rcvr channel, until the stop channel is closed. An attempt is made to
 // get the initial status and send it on the rcvr channel before receiving
@@ -1,6 +1,7 @@
 // Package version is used to store the version information for the built binaries.
 // The Version variable is set by the makefile to the value in the VERSION file
-// at the root of the repository.
+// at the root of the repository. Builds without it, e.g. by go test, report
+// a development version.
 package version
 
 import (
@@ -12,7 +13,7 @@ import (
 
 var (
 	// Version is the semver of this code.
-	Version = "UNKNOWN"
+	Version = "0.0.0-dev"
 
 	// Commit is the git commit this was built from.
 	Commit = "UNKNOWN"